@@ -0,0 +1,120 @@
+package postgres
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+)
+
+var errMalformedArray = errors.New("malformed array literal")
+
+// parseArray parses the text representation of a Postgres array, such as `{1,2,NULL}` or `{{"a b",c},{d,e}}`,
+// into nested slices of strings. NULL elements are returned as nil.
+func parseArray(s string) (interface{}, error) {
+	// arrays with lower bounds other than 1 are prefixed with their dimensions, such as `[0:1]={a,b}`
+	if strings.HasPrefix(s, "[") {
+		i := strings.Index(s, "=")
+		if i < 0 {
+			return nil, errMalformedArray
+		}
+		s = s[i+1:]
+	}
+
+	a := &arrayParser{s: s}
+	v, err := a.parseArray()
+	if err != nil {
+		return nil, err
+	}
+	if a.pos != len(a.s) {
+		return nil, errMalformedArray
+	}
+	return v, nil
+}
+
+type arrayParser struct {
+	s   string
+	pos int
+}
+
+func (a *arrayParser) parseArray() ([]interface{}, error) {
+	if a.pos >= len(a.s) || a.s[a.pos] != '{' {
+		return nil, errMalformedArray
+	}
+	a.pos++
+
+	elements := []interface{}{}
+	if a.pos < len(a.s) && a.s[a.pos] == '}' {
+		a.pos++
+		return elements, nil
+	}
+
+	for {
+		if a.pos >= len(a.s) {
+			return nil, errMalformedArray
+		}
+
+		var element interface{}
+		var err error
+		switch a.s[a.pos] {
+		case '{':
+			element, err = a.parseArray()
+		case '"':
+			element, err = a.parseQuoted()
+		default:
+			element, err = a.parseUnquoted()
+		}
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+
+		if a.pos >= len(a.s) {
+			return nil, errMalformedArray
+		}
+		switch a.s[a.pos] {
+		case ',':
+			a.pos++
+		case '}':
+			a.pos++
+			return elements, nil
+		default:
+			return nil, errMalformedArray
+		}
+	}
+}
+
+func (a *arrayParser) parseQuoted() (interface{}, error) {
+	var b bytes.Buffer
+	for a.pos++; a.pos < len(a.s); a.pos++ {
+		switch c := a.s[a.pos]; c {
+		case '\\':
+			a.pos++
+			if a.pos >= len(a.s) {
+				return nil, errMalformedArray
+			}
+			b.WriteByte(a.s[a.pos])
+		case '"':
+			a.pos++
+			return b.String(), nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return nil, errMalformedArray
+}
+
+func (a *arrayParser) parseUnquoted() (interface{}, error) {
+	start := a.pos
+	for a.pos < len(a.s) && a.s[a.pos] != ',' && a.s[a.pos] != '}' {
+		a.pos++
+	}
+
+	element := strings.TrimSpace(a.s[start:a.pos])
+	if element == "" {
+		return nil, errMalformedArray
+	}
+	if strings.EqualFold(element, "NULL") {
+		return nil, nil
+	}
+	return element, nil
+}
@@ -0,0 +1,59 @@
+package postgres
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseArray(t *testing.T) {
+	tests := []struct {
+		literal  string
+		expected interface{}
+	}{
+		{`{}`, []interface{}{}},
+		{`{1,2,3}`, []interface{}{"1", "2", "3"}},
+		{`{a,NULL,null}`, []interface{}{"a", nil, nil}},
+		{`{"NULL",NULL}`, []interface{}{"NULL", nil}},
+		{`{"a b","c,d","{e}",""}`, []interface{}{"a b", "c,d", "{e}", ""}},
+		{`{"a\"b","c\\d"}`, []interface{}{`a"b`, `c\d`}},
+		{`{ a , b }`, []interface{}{"a", "b"}},
+		{`{{1,2},{3,4}}`, []interface{}{[]interface{}{"1", "2"}, []interface{}{"3", "4"}}},
+		{`{{{a}},{{NULL}}}`, []interface{}{[]interface{}{[]interface{}{"a"}}, []interface{}{[]interface{}{nil}}}},
+		{`{{},{}}`, []interface{}{[]interface{}{}, []interface{}{}}},
+		{`[2:3]={a,b}`, []interface{}{"a", "b"}},
+		{`[0:1][1:2]={{1,2},{3,4}}`, []interface{}{[]interface{}{"1", "2"}, []interface{}{"3", "4"}}},
+		{`{"[1:2]={x}"}`, []interface{}{"[1:2]={x}"}},
+	}
+
+	for _, test := range tests {
+		actual, err := parseArray(test.literal)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.literal, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: expected %#v, got %#v", test.literal, test.expected, actual)
+		}
+	}
+}
+
+func TestParseArrayMalformed(t *testing.T) {
+	literals := []string{
+		``,
+		`1,2`,
+		`{1,2`,
+		`{1,,2}`,
+		`{1,2}x`,
+		`{"a}`,
+		`{"a\`,
+		`{"a"b}`,
+		`[1:2]{a,b}`,
+		`{{1,2}`,
+	}
+
+	for _, literal := range literals {
+		if v, err := parseArray(literal); err != errMalformedArray {
+			t.Errorf("%s: expected a malformed array error, got %#v and %v", literal, v, err)
+		}
+	}
+}
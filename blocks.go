@@ -0,0 +1,103 @@
+package postgres
+
+import (
+	"fmt"
+
+	"github.com/segment-sources/source-postgres/sqlsource/domain"
+	"github.com/segment-sources/source-postgres/sqlsource/driver"
+)
+
+// blockRange limits a scan of a table identified by IdentityRowLocation to the rows stored in the pages from up to
+// but excluding to.
+type blockRange struct {
+	from, to int64
+}
+
+// condition returns the condition selecting the rows of the range, with the bounds bound to the query arguments
+// starting at $firstArg. Postgres 14 and later only read the pages of the range.
+func (r *blockRange) condition(firstArg int) string {
+	return fmt.Sprintf(`"%[1]s" >= $%[2]d::tid AND "%[1]s" < $%[3]d::tid`, domain.RowLocationColumn, firstArg,
+		firstArg+1)
+}
+
+func (r *blockRange) args() []interface{} {
+	return []interface{}{fmt.Sprintf("(%d,0)", r.from), fmt.Sprintf("(%d,0)", r.to)}
+}
+
+// scanBlocks scans the chunk of a table identified by the location of its rows that comes after lastPkValues. Each
+// chunk is read from a range of pages expected to hold about a chunk of rows, instead of sorting every row after
+// lastPkValues by location. Ranges without rows are skipped until the end of the table.
+func (p *Postgres) scanBlocks(t *domain.Table, lastPkValues []interface{}) (driver.SqlRows, error) {
+	// the number of rows per page is estimated from the statistics, without any every page is assumed to hold one
+	sizes, err := p.query(`
+		SELECT pg_relation_size(c.oid) / current_setting('block_size')::int,
+		    CASE WHEN c.relpages > 0 AND c.reltuples > 0 THEN c.reltuples / c.relpages ELSE 1 END
+		FROM pg_catalog.pg_class c WHERE c.oid = ($1::text)::regclass`, qualifiedName(t))
+	if err != nil {
+		return nil, err
+	}
+	var blocks, rowsPerBlock float64
+	for sizes.Next() {
+		if err := sizes.Scan(&blocks, &rowsPerBlock); err != nil {
+			sizes.Close()
+			return nil, err
+		}
+	}
+	err = sizes.Err()
+	sizes.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	chunkSize := t.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+	blocksPerChunk := int64(float64(chunkSize)/rowsPerBlock) + 1
+
+	r := &blockRange{}
+	if len(lastPkValues) > 0 {
+		var offset int
+		if s, ok := lastPkValues[0].(string); ok {
+			fmt.Sscanf(s, "(%d,%d)", &r.from, &offset)
+		}
+	}
+
+	for {
+		r.to = r.from + blocksPerChunk
+		query, args := scanQuery(t, lastPkValues, r)
+
+		rows, err := p.scanRows(t, query, args)
+		if err != nil {
+			return nil, err
+		}
+		if rows.Next() {
+			return &peekedRows{SqlRows: rows, peeked: true}, nil
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		// rows added past the end of the table are found by the next scan
+		if float64(r.to) >= blocks {
+			return rows, nil
+		}
+
+		rows.Close()
+		r.from = r.to
+	}
+}
+
+// peekedRows replays the row that was read to find out whether a chunk has any.
+type peekedRows struct {
+	driver.SqlRows
+	peeked bool
+}
+
+func (r *peekedRows) Next() bool {
+	if r.peeked {
+		r.peeked = false
+		return true
+	}
+	return r.SqlRows.Next()
+}
@@ -1,6 +1,6 @@
 package main
 
-import "github.com/segment-sources/sqlsource"
+import "github.com/segment-sources/source-postgres/sqlsource"
 import "github.com/segment-sources/source-postgres"
 
 func main() {
@@ -0,0 +1,92 @@
+package postgres
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// cursorName is the name of the cursor used to stream a chunk, each cursor lives in its own transaction.
+const cursorName = "segment_scan"
+
+// cursorRows streams the rows of a server-side cursor, fetching fetchSize rows at a time.
+type cursorRows struct {
+	tx        *sqlx.Tx
+	fetchSize int
+	rows      *sqlx.Rows
+	fetched   int
+	err       error
+}
+
+// openCursor declares a cursor for query and returns its rows.
+func (p *Postgres) openCursor(query string, fetchSize int, args ...interface{}) (*cursorRows, error) {
+	var tx *sqlx.Tx
+	var err error
+	if p.snapshotTx != nil {
+		tx, err = p.beginSnapshot()
+	} else {
+		tx, err = p.Connection.Beginx()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf("DECLARE %s NO SCROLL CURSOR FOR %s", cursorName, query), args...); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	r := &cursorRows{tx: tx, fetchSize: fetchSize}
+	if r.rows, err = r.fetch(); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *cursorRows) fetch() (*sqlx.Rows, error) {
+	return r.tx.Queryx(fmt.Sprintf("FETCH %d FROM %s", r.fetchSize, cursorName))
+}
+
+func (r *cursorRows) Next() bool {
+	for r.rows != nil {
+		if r.rows.Next() {
+			r.fetched++
+			return true
+		}
+
+		if r.err = r.rows.Err(); r.err != nil {
+			return false
+		}
+		r.rows.Close()
+		r.rows = nil
+
+		// a short batch means the cursor is exhausted
+		if r.fetched < r.fetchSize {
+			return false
+		}
+
+		r.fetched = 0
+		if r.rows, r.err = r.fetch(); r.err != nil {
+			return false
+		}
+	}
+
+	return false
+}
+
+func (r *cursorRows) MapScan(dest map[string]interface{}) error {
+	return r.rows.MapScan(dest)
+}
+
+func (r *cursorRows) Err() error {
+	return r.err
+}
+
+func (r *cursorRows) Close() error {
+	if r.rows != nil {
+		r.rows.Close()
+	}
+	return r.tx.Rollback()
+}
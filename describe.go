@@ -0,0 +1,196 @@
+package postgres
+
+import (
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/segment-sources/source-postgres/sqlsource/domain"
+)
+
+// relationsQuery lists the relations that can be synced. Partitions are left out, since they are synced as part of
+// their partitioned table, but tables inheriting from another one are not partitions and are synced on their own.
+const relationsQuery = `
+	SELECT c.oid, n.nspname, c.relname,
+	    CASE c.relkind WHEN 'v' THEN 'view' WHEN 'm' THEN 'materialized_view' WHEN 'p' THEN 'partitioned' ELSE '' END
+	  FROM pg_catalog.pg_class c
+	    INNER JOIN pg_catalog.pg_namespace n ON c.relnamespace = n.oid
+	  WHERE c.relkind IN ('r', 'v', 'm', 'p')
+	    AND NOT c.relispartition
+	    AND n.nspname NOT IN ('pg_catalog', 'information_schema') AND n.nspname NOT LIKE 'pg_toast%'
+	    AND has_table_privilege(c.oid, 'SELECT')`
+
+// identityQuery returns the primary key of every table, or the smallest unique index on non-null columns of the
+// ones without, with the positions of the index columns in index order. Only the key columns of indexes are
+// considered, the columns they INCLUDE are neither part of the key nor required to be NOT NULL. Columns of
+// materialized views are never declared NOT NULL, so like views they are left without identity.
+const identityQuery = `
+	SELECT DISTINCT ON (i.indrelid) i.indrelid, (i.indkey::int2[])[0:i.indnkeyatts - 1]::text, i.indisprimary,
+	    ic.relname
+	  FROM pg_catalog.pg_index i
+	    INNER JOIN pg_catalog.pg_class ic ON i.indexrelid = ic.oid
+	    INNER JOIN pg_catalog.pg_class c ON i.indrelid = c.oid
+	  WHERE i.indisvalid
+	    AND (i.indisprimary OR i.indisunique AND i.indpred IS NULL AND i.indexprs IS NULL AND NOT EXISTS (
+	      SELECT 1 FROM pg_catalog.pg_attribute a
+	      WHERE a.attrelid = i.indrelid AND a.attnum = ANY((i.indkey::int2[])[0:i.indnkeyatts - 1]) AND NOT a.attnotnull))
+	  ORDER BY i.indrelid, i.indisprimary DESC, i.indnkeyatts, i.indexrelid`
+
+// columnsQuery lists the columns of every relation of relationsQuery in table order, so the columns of partitions,
+// system catalogs and relations that can't be read are never sent over. Comments and defaults are joined instead of
+// looked up column by column, which is what makes introspection slow on catalogs with many tables.
+const columnsQuery = `
+	WITH relations (oid, schema_name, table_name, kind) AS (` + relationsQuery + `)
+	SELECT a.attrelid, a.attnum, a.attname, pg_catalog.format_type(a.atttypid, a.atttypmod), t.typname,
+	    NOT a.attnotnull, COALESCE(pg_catalog.pg_get_expr(d.adbin, d.adrelid), ''), COALESCE(ds.description, '')
+	  FROM relations r
+	    INNER JOIN pg_catalog.pg_attribute a ON a.attrelid = r.oid
+	    INNER JOIN pg_catalog.pg_type t ON a.atttypid = t.oid
+	    LEFT JOIN pg_catalog.pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+	    LEFT JOIN pg_catalog.pg_description ds
+	      ON ds.objoid = a.attrelid AND ds.classoid = 'pg_catalog.pg_class'::regclass AND ds.objsubid = a.attnum
+	  WHERE a.attnum > 0 AND NOT a.attisdropped
+	  ORDER BY a.attrelid, a.attnum`
+
+type catalogRelation struct {
+	schema, name, kind string
+	identity           string
+	identityIndex      string
+	// keyPositions maps the attnum of key columns to their position in the key, starting at 1
+	keyPositions map[int]int
+}
+
+// Describe reads the catalog in three queries, relations, their identity and their columns, and streams the
+// columns into the description. Relations and identities are held by oid, so every column is matched in memory
+// rather than by joining the whole catalog in a single query.
+//
+// Every table is identified by its primary key, or by the smallest unique index on non-null columns if it has
+// none. Key columns are recorded in the order of the index, so keyset pagination follows it. Tables with neither
+// are listed with the ctid identity and disabled. Views and materialized views are listed disabled too, their
+// identity columns have to be declared in the schema.
+func (p *Postgres) Describe() (*domain.Description, error) {
+	// the queries share a snapshot, so they see the same catalog
+	tx, err := p.Connection.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SET TRANSACTION ISOLATION LEVEL REPEATABLE READ, READ ONLY"); err != nil {
+		return nil, err
+	}
+
+	relations, err := describeRelations(tx)
+	if err != nil {
+		return nil, err
+	}
+	if err := describeIdentities(tx, relations); err != nil {
+		return nil, err
+	}
+
+	res := domain.NewDescription()
+
+	rows, err := tx.Queryx(columnsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var oid uint32
+		var attnum int
+		var column string
+		m := &domain.ColumnMetadata{}
+		if err := rows.Scan(&oid, &attnum, &column, &m.DataType, &m.UDTName, &m.Nullable, &m.Default,
+			&m.Comment); err != nil {
+			return nil, err
+		}
+		r, ok := relations[oid]
+		if !ok {
+			continue
+		}
+
+		position := r.keyPositions[attnum]
+		res.AddColumn(&domain.Column{
+			Name:         column,
+			Schema:       r.schema,
+			Table:        r.name,
+			IsPrimaryKey: position > 0,
+			KeyPosition:  position,
+			Metadata:     m,
+		})
+
+		t, _ := res.Table(r.schema, r.name)
+		t.Kind = r.kind
+		t.Identity = r.identity
+		t.IdentityIndex = r.identityIndex
+		if t.Identity == "" && (r.kind == "" || r.kind == domain.KindPartitioned) {
+			t.Identity = domain.IdentityRowLocation
+		}
+		t.Disabled = t.Identity == domain.IdentityRowLocation || r.kind == domain.KindView ||
+			r.kind == domain.KindMaterializedView
+	}
+
+	return res, rows.Err()
+}
+
+// describeRelations returns the relations that can be synced by oid.
+func describeRelations(tx *sqlx.Tx) (map[uint32]*catalogRelation, error) {
+	rows, err := tx.Queryx(relationsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	relations := map[uint32]*catalogRelation{}
+	for rows.Next() {
+		var oid uint32
+		r := &catalogRelation{}
+		if err := rows.Scan(&oid, &r.schema, &r.name, &r.kind); err != nil {
+			return nil, err
+		}
+		relations[oid] = r
+	}
+	return relations, rows.Err()
+}
+
+// describeIdentities records the identity of the relations and the positions of their key columns.
+func describeIdentities(tx *sqlx.Tx, relations map[uint32]*catalogRelation) error {
+	rows, err := tx.Queryx(identityQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var oid uint32
+		var indkey, index string
+		var primary bool
+		if err := rows.Scan(&oid, &indkey, &primary, &index); err != nil {
+			return err
+		}
+		r, ok := relations[oid]
+		if !ok {
+			continue
+		}
+
+		positions, err := parseArray(indkey)
+		if err != nil {
+			return err
+		}
+		r.keyPositions = map[int]int{}
+		for i, attnum := range positions.([]interface{}) {
+			s, _ := attnum.(string)
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return err
+			}
+			r.keyPositions[n] = i + 1
+		}
+
+		r.identity, r.identityIndex = domain.IdentityUniqueIndex, index
+		if primary {
+			r.identity = domain.IdentityPrimaryKey
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,183 @@
+package postgres
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/segment-sources/source-postgres/sqlsource/domain"
+)
+
+// exec runs the statements, failing the test at the first error.
+func exec(tb testing.TB, db *sqlx.DB, statements ...string) {
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			tb.Fatalf("%s: %v", statement, err)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	db := testDB(t)
+	defer db.Close()
+
+	exec(t, db,
+		`CREATE SCHEMA describe_test`,
+		`CREATE TABLE describe_test.films (code int, title text, len interval, PRIMARY KEY (title, code))`,
+		`CREATE TABLE describe_test.film_archive () INHERITS (describe_test.films)`,
+		`CREATE TABLE describe_test.accounts (a int NOT NULL, b int NOT NULL, note text)`,
+		`CREATE UNIQUE INDEX accounts_b_a_key ON describe_test.accounts (b, a)`,
+		`CREATE UNIQUE INDEX accounts_a_key ON describe_test.accounts (a) INCLUDE (note)`,
+		`CREATE TABLE describe_test.logs (message text)`,
+		`CREATE TABLE describe_test.events (id int, at date, PRIMARY KEY (id, at)) PARTITION BY RANGE (at)`,
+		`CREATE TABLE describe_test.events_1 PARTITION OF describe_test.events
+			FOR VALUES FROM ('2020-01-01') TO ('2021-01-01')`,
+		`CREATE VIEW describe_test.recent AS SELECT * FROM describe_test.films`,
+		`CREATE MATERIALIZED VIEW describe_test.totals AS SELECT title, count(*) FROM describe_test.films GROUP BY 1`,
+		`CREATE UNIQUE INDEX totals_title_key ON describe_test.totals (title)`,
+	)
+	defer db.Exec(`DROP SCHEMA describe_test CASCADE`)
+
+	p := &Postgres{Connection: db}
+	d, err := p.Describe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		table         string
+		kind          string
+		identity      string
+		identityIndex string
+		primaryKeys   []string
+		columns       []string
+		disabled      bool
+	}{
+		{"films", "", domain.IdentityPrimaryKey, "", []string{"title", "code"}, []string{"code", "title", "len"}, false},
+		{"film_archive", "", domain.IdentityRowLocation, "", nil, []string{"code", "title", "len"}, true},
+		{"accounts", "", domain.IdentityUniqueIndex, "accounts_a_key", []string{"a"}, []string{"a", "b", "note"}, false},
+		{"logs", "", domain.IdentityRowLocation, "", nil, []string{"message"}, true},
+		{"events", domain.KindPartitioned, domain.IdentityPrimaryKey, "", []string{"id", "at"}, []string{"id", "at"},
+			false},
+		{"recent", domain.KindView, "", "", nil, []string{"code", "title", "len"}, true},
+		{"totals", domain.KindMaterializedView, "", "", nil, []string{"title", "count"}, true},
+	}
+
+	for _, test := range tests {
+		table, ok := d.Table("describe_test", test.table)
+		if !ok {
+			t.Errorf("%s: not described", test.table)
+			continue
+		}
+		if table.Kind != test.kind || table.Identity != test.identity || table.IdentityIndex != test.identityIndex ||
+			table.Disabled != test.disabled {
+			t.Errorf("%s: expected kind %q, identity %q %q and disabled %v, got %q, %q %q and %v", test.table,
+				test.kind, test.identity, test.identityIndex, test.disabled, table.Kind, table.Identity,
+				table.IdentityIndex, table.Disabled)
+		}
+		if len(table.PrimaryKeys) > 0 || len(test.primaryKeys) > 0 {
+			if !reflect.DeepEqual(table.PrimaryKeys, test.primaryKeys) {
+				t.Errorf("%s: expected the key %v, got %v", test.table, test.primaryKeys, table.PrimaryKeys)
+			}
+		}
+		if !reflect.DeepEqual(table.Columns, test.columns) {
+			t.Errorf("%s: expected the columns %v, got %v", test.table, test.columns, table.Columns)
+		}
+	}
+
+	if _, ok := d.Table("describe_test", "events_1"); ok {
+		t.Error("expected the partition to be left out")
+	}
+	if _, ok := d.Table("pg_catalog", "pg_class"); ok {
+		t.Error("expected the system catalogs to be left out")
+	}
+}
+
+// Sizes of the catalog generated by createDescribeCatalog.
+const (
+	benchTables      = 3000
+	benchPartitioned = 100
+	benchPartitions  = 10
+)
+
+// describeBudget is how long describing the generated catalog may take.
+const describeBudget = 10 * time.Second
+
+// createDescribeCatalog generates a catalog of thousands of tables, partitions and indexes in the describe_bench
+// schema.
+func createDescribeCatalog(tb testing.TB, db *sqlx.DB) {
+	for i := 0; i < benchTables; i++ {
+		exec(tb, db,
+			fmt.Sprintf(`CREATE TABLE describe_bench.t%d (id int PRIMARY KEY, name text NOT NULL,
+				created_at timestamptz DEFAULT now(), amount numeric(10,2))`, i),
+			fmt.Sprintf(`CREATE INDEX ON describe_bench.t%d (created_at)`, i),
+			fmt.Sprintf(`COMMENT ON COLUMN describe_bench.t%d.name IS 'name'`, i),
+		)
+	}
+	for i := 0; i < benchPartitioned; i++ {
+		exec(tb, db, fmt.Sprintf(`CREATE TABLE describe_bench.p%d (id int, at date, PRIMARY KEY (id, at))
+			PARTITION BY RANGE (at)`, i))
+		for j := 0; j < benchPartitions; j++ {
+			exec(tb, db, fmt.Sprintf(`CREATE TABLE describe_bench.p%d_%d PARTITION OF describe_bench.p%d
+				FOR VALUES FROM ('%d-01-01') TO ('%d-01-01')`, i, j, i, 2000+j, 2001+j))
+		}
+	}
+	exec(tb, db, `ANALYZE`)
+}
+
+// describeCatalog describes the generated catalog and checks that every one of its tables was listed.
+func describeCatalog(tb testing.TB, p *Postgres) {
+	d, err := p.Describe()
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	count := 0
+	for t := range d.Iter() {
+		if t.SchemaName == "describe_bench" {
+			count++
+		}
+	}
+	if count != benchTables+benchPartitioned {
+		tb.Fatalf("expected %d tables, got %d", benchTables+benchPartitioned, count)
+	}
+}
+
+// TestDescribeDuration checks that describing a catalog of thousands of tables stays within describeBudget.
+func TestDescribeDuration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("generating the catalog is slow")
+	}
+	db := testDB(t)
+	defer db.Close()
+
+	exec(t, db, `CREATE SCHEMA describe_bench`)
+	defer db.Exec(`DROP SCHEMA describe_bench CASCADE`)
+	createDescribeCatalog(t, db)
+
+	start := time.Now()
+	describeCatalog(t, &Postgres{Connection: db})
+	if elapsed := time.Since(start); elapsed > describeBudget {
+		t.Errorf("expected the catalog to be described within %v, took %v", describeBudget, elapsed)
+	}
+}
+
+// BenchmarkDescribe describes a catalog of thousands of tables, partitions and indexes. The catalog is generated
+// before the first run and dropped at the end.
+func BenchmarkDescribe(b *testing.B) {
+	db := testDB(b)
+	defer db.Close()
+
+	exec(b, db, `CREATE SCHEMA describe_bench`)
+	defer db.Exec(`DROP SCHEMA describe_bench CASCADE`)
+	createDescribeCatalog(b, db)
+
+	p := &Postgres{Connection: db}
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		describeCatalog(b, p)
+	}
+}
@@ -4,35 +4,56 @@ import (
 	"bytes"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	_ "github.com/jackc/pgx/stdlib"
 	"github.com/jmoiron/sqlx"
-	"github.com/segment-sources/sqlsource/domain"
-	"github.com/segment-sources/sqlsource/driver"
+	"github.com/segment-sources/source-postgres/sqlsource/domain"
+	"github.com/segment-sources/source-postgres/sqlsource/driver"
 )
 
-const chunkSize = 1000000
+// defaultChunkSize is the number of rows selected by each query of a table scan, unless the table sets its own.
+const defaultChunkSize = 1000000
 
-type tableDescriptionRow struct {
-	Catalog    string `db:"table_catalog"`
-	SchemaName string `db:"table_schema"`
-	TableName  string `db:"table_name"`
-	ColumnName string `db:"column_name"`
-	IsPrimary  bool   `db:"is_primary_key"`
-}
+// minServerVersion is the oldest server_version_num supported, Postgres 11 is the first to record the key columns
+// of indexes in pg_index.indnkeyatts and to advance replication slots.
+const minServerVersion = 110000
 
 type Postgres struct {
 	Connection *sqlx.DB
+
+	slot      string
+	slotReady bool
+
+	snapshot   string
+	snapshotTx *sqlx.Tx
+
+	numericAsFloat bool
+	specialValues  string
+	typesMu        sync.Mutex
+	types          map[string]map[string]string
 }
 
 func (p *Postgres) Init(c *domain.Config) error {
-	var extraOptions bytes.Buffer
-	if len(c.ExtraOptions) > 0 {
-		extraOptions.WriteRune('?')
-		extraOptions.WriteString(strings.Join(c.ExtraOptions, "&"))
+	switch c.SpecialValues {
+	case "", domain.SpecialValuesNull, domain.SpecialValuesString:
+	default:
+		return fmt.Errorf("unknown special values representation %q, expected %q or %q", c.SpecialValues,
+			domain.SpecialValuesNull, domain.SpecialValuesString)
+	}
+
+	options := append([]string{}, c.ExtraOptions...)
+	if !hasOption(options, "IntervalStyle") {
+		// intervals are sent as ISO 8601 durations
+		options = append(options, "IntervalStyle=iso_8601")
 	}
 
+	var extraOptions bytes.Buffer
+	extraOptions.WriteRune('?')
+	extraOptions.WriteString(strings.Join(options, "&"))
+
 	connectionString := fmt.Sprintf(
 		"postgres://%s:%s@%s:%s/%s%s",
 		c.Username, c.Password, c.Hostname, c.Port, c.Database, extraOptions.String(),
@@ -42,102 +63,278 @@ func (p *Postgres) Init(c *domain.Config) error {
 	if err != nil {
 		return err
 	}
+	if err := checkServerVersion(db); err != nil {
+		db.Close()
+		return err
+	}
 
 	p.Connection = db
+	p.slot = c.ReplicationSlot
+	p.numericAsFloat = c.NumericAsFloat
+	p.specialValues = c.SpecialValues
+	p.types = map[string]map[string]string{}
+
+	if c.Snapshot && !c.Init {
+		return p.exportSnapshot()
+	}
 
 	return nil
 }
 
-func (p *Postgres) Scan(t *domain.Table, lastPkValues []interface{}) (driver.SqlRows, error) {
-	// in most cases whereClause will simply look like "id" > 114, but since the source supports compound PKs
-	// we must be able to include all PK columns in the query. For example, for a table with 3-column PK:
-	//	a | b | c
-	//	---+---+---
-	//	1 | 1 | 1
-	//	1 | 1 | 2
-	//	1 | 2 | 1
-	//	1 | 2 | 2
-	//	2 | 1 | 1
-	//
-	// whereClause selecting records after (1, 1, 1) should look like:
-	// a > 1 OR a = 1 AND b > 1 OR a = 1 AND b = 1 AND c > 1
-	whereClause := "true"
-	if len(lastPkValues) > 0 {
-		// {"a > 1", "a = 1 AND b > 1", "a = 1 AND b = 1 AND c > 1"}
-		whereOrList := []string{}
-
-		for i, pk := range t.PrimaryKeys {
-			// {"a = 1", "b = 1", "c > 1"}
-			choiceAndList := []string{}
-			for j := 0; j < i; j++ {
-				choiceAndList = append(choiceAndList, fmt.Sprintf(`"%s" = $%d`, t.PrimaryKeys[j], j+1))
-			}
-			choiceAndList = append(choiceAndList, fmt.Sprintf(`"%s" > $%d`, pk, i+1))
-			whereOrList = append(whereOrList, strings.Join(choiceAndList, " AND "))
+// checkServerVersion fails if the server is older than minServerVersion.
+func checkServerVersion(db *sqlx.DB) error {
+	var version int
+	var name string
+	row := db.QueryRow(`SELECT current_setting('server_version_num')::int, current_setting('server_version')`)
+	if err := row.Scan(&version, &name); err != nil {
+		return err
+	}
+	if version < minServerVersion {
+		return fmt.Errorf("Postgres %s is not supported, the source requires Postgres 11 or later", name)
+	}
+	return nil
+}
+
+// hasOption reports whether the connection options set the named parameter.
+func hasOption(options []string, name string) bool {
+	for _, o := range options {
+		if strings.HasPrefix(o, name+"=") {
+			return true
 		}
-		whereClause = strings.Join(whereOrList, " OR ")
 	}
+	return false
+}
 
-	orderByList := make([]string, 0, len(t.PrimaryKeys))
-	for _, column := range t.PrimaryKeys {
-		orderByList = append(orderByList, fmt.Sprintf(`"%s"`, column))
+func (p *Postgres) Scan(t *domain.Table, lastPkValues []interface{}) (driver.SqlRows, error) {
+	if err := p.columnTypes(t); err != nil {
+		return nil, err
 	}
-	orderByClause := strings.Join(orderByList, ", ")
 
-	query := fmt.Sprintf("SELECT %s FROM %q.%q WHERE %s ORDER BY %s LIMIT %d", t.ColumnToSQL(), t.SchemaName,
-		t.TableName, whereClause, orderByClause, chunkSize)
+	if t.Identity == domain.IdentityRowLocation {
+		return p.scanBlocks(t, lastPkValues)
+	}
+
+	query, args := scanQuery(t, lastPkValues, nil)
+	return p.scanRows(t, query, args)
+}
 
+// scanRows runs the query of a chunk.
+func (p *Postgres) scanRows(t *domain.Table, query string, args []interface{}) (driver.SqlRows, error) {
 	logger := logrus.WithFields(logrus.Fields{
 		"query": query,
-		"args": lastPkValues,
+		"args":  args,
 	})
 	logger.Debugf("Executing query")
-	return p.Connection.Queryx(query, lastPkValues...)
+
+	// with a fetch size the chunk is streamed through a cursor, so only fetchSize rows are buffered at a time
+	if t.FetchSize > 0 {
+		return p.openCursor(query, t.FetchSize, args...)
+	}
+
+	rows, err := p.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
 }
 
-func (p *Postgres) Transform(row map[string]interface{}) map[string]interface{} {
-	return row
+// scanQuery returns the query selecting the chunk of the table that comes after lastPkValues, and its arguments.
+// Tables identified by the location of their rows are also limited to a range of pages.
+func scanQuery(t *domain.Table, lastPkValues []interface{}, blocks *blockRange) (string, []interface{}) {
+	whereClause := "true"
+	if len(lastPkValues) > 0 {
+		whereClause = keysetCondition(t, 1)
+	}
+
+	args := append([]interface{}{}, lastPkValues...)
+
+	if blocks != nil {
+		whereClause = fmt.Sprintf("(%s) AND %s", whereClause, blocks.condition(len(args)+1))
+		args = append(args, blocks.args()...)
+	}
+
+	if t.Filter != "" {
+		whereClause = fmt.Sprintf("(%s) AND (%s)", whereClause, t.Filter)
+	}
+
+	// tables split into key ranges only scan their own range of the leading PK column
+	if r := t.Range; r != nil {
+		if r.Lower != nil {
+			args = append(args, r.Lower)
+			whereClause = fmt.Sprintf(`(%s) AND "%s" >= $%d`, whereClause, t.KeyColumns()[0], len(args))
+		}
+		if r.Upper != nil {
+			args = append(args, r.Upper)
+			whereClause = fmt.Sprintf(`(%s) AND "%s" < $%d`, whereClause, t.KeyColumns()[0], len(args))
+		}
+	}
+
+	// incremental tables only select rows changed since the last run and up to the marker captured when the scan
+	// started, minus the table's marker lag. Rows committed after the marker was captured are only picked up by the
+	// next run if their marker is above it, which the lag leaves room for.
+	if t.IsIncremental() {
+		markerList := []string{}
+		if t.State.LastMarker != nil {
+			args = append(args, t.State.LastMarker)
+			markerList = append(markerList, fmt.Sprintf(`"%s" > $%d`, t.MarkerColumn, len(args)))
+		}
+		args = append(args, t.State.NextMarker)
+		markerList = append(markerList, fmt.Sprintf(`"%s" <= $%d`, t.MarkerColumn, len(args)))
+		whereClause = fmt.Sprintf("(%s) AND %s", whereClause, strings.Join(markerList, " AND "))
+	}
+
+	orderByList := make([]string, 0, len(t.KeyColumns()))
+	for _, column := range t.KeyColumns() {
+		orderByList = append(orderByList, fmt.Sprintf(`"%s"`, column))
+	}
+	orderByClause := strings.Join(orderByList, ", ")
+
+	chunkSize := t.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+
+	selectList := t.ColumnToSQL()
+	if t.Identity == domain.IdentityRowLocation {
+		selectList = fmt.Sprintf("%s, %s", selectList, domain.RowLocationColumn)
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY %s LIMIT %d", selectList, relation(t), whereClause,
+		orderByClause, chunkSize)
+	return query, args
 }
 
-func (p *Postgres) Describe() (*domain.Description, error) {
-	describeQuery := `
-    with o_1 as (SELECT
-        _s.nspname AS table_schema,
-        _t.relname  AS table_name,
-        c.conkey AS column_positions
-      FROM pg_catalog.pg_constraint c
-        LEFT JOIN pg_catalog.pg_class _t ON c.conrelid = _t.oid
-        LEFT JOIN pg_catalog.pg_class referenced_table ON c.confrelid = referenced_table.oid
-        LEFT JOIN pg_catalog.pg_namespace _s ON _t.relnamespace = _s.oid
-        LEFT JOIN pg_catalog.pg_namespace referenced_schema ON referenced_table.relnamespace = referenced_schema.oid
-      WHERE c.contype = 'p')
-
-    select c.table_catalog, c.table_schema, c.table_name, c.column_name, CASE WHEN c.ordinal_position = ANY(o_1.column_positions) THEN true ELSE false END as "is_primary_key"
-        FROM o_1 INNER JOIN information_schema.columns c
-            ON o_1.table_schema = c.table_schema
-            AND o_1.table_name = c.table_name;
-    `
-
-	res := domain.NewDescription()
-
-	rows, err := p.Connection.Queryx(describeQuery)
+// MaxMarker returns the current maximum of the table's marker column, minus the table's marker lag which is an
+// interval such as "5 minutes". The value is returned in its text representation so it can be saved in the state
+// file and passed back to Postgres regardless of the column type.
+func (p *Postgres) MaxMarker(t *domain.Table) (interface{}, error) {
+	query := fmt.Sprintf(`SELECT max("%s")::text FROM %s`, t.MarkerColumn, relation(t))
+	args := []interface{}{}
+	if t.MarkerLag != "" {
+		query = fmt.Sprintf(`SELECT (max("%s") - $1::interval)::text FROM %s`, t.MarkerColumn, relation(t))
+		args = append(args, t.MarkerLag)
+	}
+
+	rows, err := p.query(query, args...)
 	if err != nil {
 		return nil, err
 	}
-
 	defer rows.Close()
 
+	var marker *string
 	for rows.Next() {
-		row := &tableDescriptionRow{}
-		if err := rows.StructScan(row); err != nil {
+		if err := rows.Scan(&marker); err != nil {
 			return nil, err
 		}
-		res.AddColumn(&domain.Column{Name: row.ColumnName, Schema: row.SchemaName, Table: row.TableName, IsPrimaryKey: row.IsPrimary})
 	}
-
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
+	if marker == nil {
+		return nil, nil
+	}
+
+	return *marker, nil
+}
+
+// KeyText returns the key values of the table as Postgres prints them. pgx reads timestamps without a time zone
+// as times in the local zone, and dates as local midnight, which would not be parsed back into the same values.
+func (p *Postgres) KeyText(t *domain.Table, values []interface{}) []interface{} {
+	types := p.cachedColumnTypes(t)
+
+	res := make([]interface{}, 0, len(values))
+	for i, v := range values {
+		if tm, ok := v.(time.Time); ok && i < len(t.KeyColumns()) {
+			switch types[t.KeyColumns()[i]] {
+			case "timestamp":
+				v = tm.UTC().Format("2006-01-02 15:04:05.999999")
+			case "date":
+				v = tm.Format("2006-01-02")
+			}
+		}
+		res = append(res, v)
+	}
+	return res
+}
 
-	return res, nil
+// relation returns what the table's rows are selected from: the table itself, the partition being scanned, or the
+// table's query wrapped as a subquery. Plain tables are selected without the rows of the tables inheriting from them,
+// which are synced on their own.
+func relation(t *domain.Table) string {
+	switch {
+	case t.Partition != "":
+		return t.Partition
+	case t.Kind == domain.KindQuery:
+		// the query goes on its own lines, so a trailing comment doesn't swallow the end of the subquery
+		query := strings.TrimRight(t.Query, "; \t\r\n")
+		return fmt.Sprintf("(\n%s\n) AS %q", query, t.TableName)
+	case t.Kind == "":
+		return "ONLY " + qualifiedName(t)
+	}
+	return qualifiedName(t)
+}
+
+// qualifiedName returns the quoted name of the table or of the partition being scanned.
+func qualifiedName(t *domain.Table) string {
+	if t.Partition != "" {
+		return t.Partition
+	}
+	return fmt.Sprintf("%q.%q", t.SchemaName, t.TableName)
+}
+
+// ValidateFilter checks the table's filter by planning a query that uses it.
+func (p *Postgres) ValidateFilter(t *domain.Table) error {
+	rows, err := p.query(fmt.Sprintf("EXPLAIN SELECT %s FROM %s WHERE (%s)", t.ColumnToSQL(), relation(t), t.Filter))
+	if err != nil {
+		return fmt.Errorf("%s.%s: invalid filter: %v", t.SchemaName, t.TableName, err)
+	}
+	return rows.Close()
+}
+
+// QueryColumns returns the names of the columns returned by the table's query, without running it.
+func (p *Postgres) QueryColumns(t *domain.Table) ([]string, error) {
+	rows, err := p.query(fmt.Sprintf("SELECT * FROM %s LIMIT 0", relation(t)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return rows.Columns()
+}
+
+// keysetCondition returns the condition selecting the rows that come after the primary key bound to the query
+// arguments starting at $firstArg. For a table with a 3-column PK (a, b, c) it looks like:
+//
+//	("a", "b", "c") > ($1, $2, $3)
+//
+// which lets the planner use the PK index for the range. Tables with ExpandedKeyset set use the equivalent
+// condition spelled out column by column instead:
+//
+//	a > $1 OR a = $1 AND b > $2 OR a = $1 AND b = $2 AND c > $3
+func keysetCondition(t *domain.Table, firstArg int) string {
+	keys := t.KeyColumns()
+
+	if !t.ExpandedKeyset {
+		columns := make([]string, 0, len(keys))
+		params := make([]string, 0, len(keys))
+		for i, pk := range keys {
+			columns = append(columns, fmt.Sprintf(`"%s"`, pk))
+			params = append(params, fmt.Sprintf("$%d", firstArg+i))
+		}
+		return fmt.Sprintf("(%s) > (%s)", strings.Join(columns, ", "), strings.Join(params, ", "))
+	}
+
+	// {"a > 1", "a = 1 AND b > 1", "a = 1 AND b = 1 AND c > 1"}
+	whereOrList := []string{}
+
+	for i, pk := range keys {
+		// {"a = 1", "b = 1", "c > 1"}
+		choiceAndList := []string{}
+		for j := 0; j < i; j++ {
+			choiceAndList = append(choiceAndList, fmt.Sprintf(`"%s" = $%d`, keys[j], firstArg+j))
+		}
+		choiceAndList = append(choiceAndList, fmt.Sprintf(`"%s" > $%d`, pk, firstArg+i))
+		whereOrList = append(whereOrList, strings.Join(choiceAndList, " AND "))
+	}
+	return strings.Join(whereOrList, " OR ")
 }
@@ -0,0 +1,235 @@
+package postgres
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/segment-sources/source-postgres/sqlsource/domain"
+)
+
+// testDSNVariable names the environment variable holding the connection string of the database used by tests that
+// need one, such as "postgres://postgres@localhost:5432/postgres?sslmode=disable". They are skipped without it.
+const testDSNVariable = "POSTGRES_TEST_DSN"
+
+// testDB connects to the test database, or skips the test if there is none.
+func testDB(tb testing.TB) *sqlx.DB {
+	dsn := os.Getenv(testDSNVariable)
+	if dsn == "" {
+		tb.Skipf("%s is not set", testDSNVariable)
+	}
+
+	db, err := sqlx.Connect("pgx", dsn)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return db
+}
+
+func TestKeysetCondition(t *testing.T) {
+	tests := []struct {
+		name     string
+		table    *domain.Table
+		firstArg int
+		expected string
+	}{
+		{
+			name:     "single column",
+			table:    &domain.Table{PrimaryKeys: []string{"id"}},
+			firstArg: 1,
+			expected: `("id") > ($1)`,
+		},
+		{
+			name:     "compound key",
+			table:    &domain.Table{PrimaryKeys: []string{"a", "b", "c"}},
+			firstArg: 1,
+			expected: `("a", "b", "c") > ($1, $2, $3)`,
+		},
+		{
+			name:     "arguments offset",
+			table:    &domain.Table{PrimaryKeys: []string{"a", "b"}},
+			firstArg: 3,
+			expected: `("a", "b") > ($3, $4)`,
+		},
+		{
+			name:     "expanded single column",
+			table:    &domain.Table{PrimaryKeys: []string{"id"}, ExpandedKeyset: true},
+			firstArg: 1,
+			expected: `"id" > $1`,
+		},
+		{
+			name:     "expanded compound key",
+			table:    &domain.Table{PrimaryKeys: []string{"a", "b", "c"}, ExpandedKeyset: true},
+			firstArg: 1,
+			expected: `"a" > $1 OR "a" = $1 AND "b" > $2 OR "a" = $1 AND "b" = $2 AND "c" > $3`,
+		},
+		{
+			name:     "row location",
+			table:    &domain.Table{Identity: domain.IdentityRowLocation},
+			firstArg: 1,
+			expected: `("ctid") > ($1)`,
+		},
+	}
+
+	for _, test := range tests {
+		if actual := keysetCondition(test.table, test.firstArg); actual != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestScanQuery(t *testing.T) {
+	table := &domain.Table{
+		SchemaName:  "public",
+		TableName:   "films",
+		PrimaryKeys: []string{"code", "title"},
+		Columns:     []string{"code", "title", "len"},
+		ChunkSize:   100,
+	}
+
+	query, args := scanQuery(table, nil, nil)
+	expected := `SELECT "code", "title", "len" FROM ONLY "public"."films" WHERE true ORDER BY "code", "title" LIMIT 100`
+	if query != expected || len(args) != 0 {
+		t.Errorf("expected %s without arguments, got %s with %v", expected, query, args)
+	}
+
+	query, args = scanQuery(table, []interface{}{int64(1), "a"}, nil)
+	expected = `SELECT "code", "title", "len" FROM ONLY "public"."films" WHERE ("code", "title") > ($1, $2) ` +
+		`ORDER BY "code", "title" LIMIT 100`
+	if query != expected || !reflect.DeepEqual(args, []interface{}{int64(1), "a"}) {
+		t.Errorf("expected %s with [1 a], got %s with %v", expected, query, args)
+	}
+}
+
+func TestScanQueryBlockRange(t *testing.T) {
+	table := &domain.Table{
+		SchemaName: "public",
+		TableName:  "logs",
+		Columns:    []string{"message"},
+		Identity:   domain.IdentityRowLocation,
+		ChunkSize:  100,
+	}
+
+	query, args := scanQuery(table, []interface{}{"(3,7)"}, &blockRange{from: 3, to: 5})
+	expected := `SELECT "message", ctid FROM ONLY "public"."logs" WHERE (("ctid") > ($1)) AND "ctid" >= $2::tid ` +
+		`AND "ctid" < $3::tid ORDER BY "ctid" LIMIT 100`
+	if query != expected || !reflect.DeepEqual(args, []interface{}{"(3,7)", "(3,0)", "(5,0)"}) {
+		t.Errorf("expected %s with [(3,7) (3,0) (5,0)], got %s with %v", expected, query, args)
+	}
+}
+
+func TestRelation(t *testing.T) {
+	tests := []struct {
+		name     string
+		table    *domain.Table
+		expected string
+	}{
+		{
+			name:     "table",
+			table:    &domain.Table{SchemaName: "public", TableName: "films"},
+			expected: `ONLY "public"."films"`,
+		},
+		{
+			name:     "view",
+			table:    &domain.Table{SchemaName: "public", TableName: "recent", Kind: domain.KindView},
+			expected: `"public"."recent"`,
+		},
+		{
+			name:     "partition",
+			table:    &domain.Table{SchemaName: "public", TableName: "events", Partition: `"public"."events_1"`},
+			expected: `"public"."events_1"`,
+		},
+		{
+			name:     "query",
+			table:    &domain.Table{TableName: "totals", Kind: domain.KindQuery, Query: "SELECT 1 AS id"},
+			expected: "(\nSELECT 1 AS id\n) AS \"totals\"",
+		},
+		{
+			name:     "query ending with a semicolon",
+			table:    &domain.Table{TableName: "totals", Kind: domain.KindQuery, Query: "SELECT 1 AS id ;\n"},
+			expected: "(\nSELECT 1 AS id\n) AS \"totals\"",
+		},
+		{
+			name:     "query ending with a comment",
+			table:    &domain.Table{TableName: "totals", Kind: domain.KindQuery, Query: "SELECT 1 AS id -- totals"},
+			expected: "(\nSELECT 1 AS id -- totals\n) AS \"totals\"",
+		},
+	}
+
+	for _, test := range tests {
+		if actual := relation(test.table); actual != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, actual)
+		}
+	}
+}
+
+// TestScanQueryUsesPrimaryKey checks that Postgres pages through a compound key with the primary key index, in
+// both forms of the keyset condition.
+func TestScanQueryUsesPrimaryKey(t *testing.T) {
+	db := testDB(t)
+	defer db.Close()
+
+	// everything happens in a transaction that is rolled back, which also keeps the prepared statement on the same
+	// connection
+	tx, err := db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		`CREATE TABLE public.keyset_test (a int, b text, c int, payload text, PRIMARY KEY (a, b, c))`,
+		`INSERT INTO public.keyset_test SELECT i / 100, (i / 10 % 10)::text, i % 10, md5(i::text)
+			FROM generate_series(1, 100000) i`,
+		`ANALYZE public.keyset_test`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, expanded := range []bool{false, true} {
+		table := &domain.Table{
+			SchemaName:     "public",
+			TableName:      "keyset_test",
+			PrimaryKeys:    []string{"a", "b", "c"},
+			Columns:        []string{"a", "b", "c", "payload"},
+			ChunkSize:      1000,
+			ExpandedKeyset: expanded,
+		}
+		query, _ := scanQuery(table, []interface{}{500, "5", 5}, nil)
+
+		if _, err := tx.Exec("PREPARE keyset_scan (int, text, int) AS " + query); err != nil {
+			t.Fatal(err)
+		}
+
+		rows, err := tx.Queryx("EXPLAIN EXECUTE keyset_scan (500, '5', 5)")
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan := []string{}
+		for rows.Next() {
+			var line string
+			if err := rows.Scan(&line); err != nil {
+				t.Fatal(err)
+			}
+			plan = append(plan, line)
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+
+		if !strings.Contains(strings.Join(plan, "\n"), "Index Scan using keyset_test_pkey") {
+			t.Errorf("expanded %v: expected an index scan on the primary key, got:\n%s", expanded,
+				strings.Join(plan, "\n"))
+		}
+
+		if _, err := tx.Exec("DEALLOCATE keyset_scan"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package postgres
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// EWKB flags set on the geometry type by PostGIS.
+const (
+	ewkbZ    = 0x80000000
+	ewkbM    = 0x40000000
+	ewkbSRID = 0x20000000
+)
+
+// WKB geometry types, the ones GeoJSON can represent.
+const (
+	wkbPoint              = 1
+	wkbLineString         = 2
+	wkbPolygon            = 3
+	wkbMultiPoint         = 4
+	wkbMultiLineString    = 5
+	wkbMultiPolygon       = 6
+	wkbGeometryCollection = 7
+)
+
+var geoJSONTypes = map[uint32]string{
+	wkbPoint:              "Point",
+	wkbLineString:         "LineString",
+	wkbPolygon:            "Polygon",
+	wkbMultiPoint:         "MultiPoint",
+	wkbMultiLineString:    "MultiLineString",
+	wkbMultiPolygon:       "MultiPolygon",
+	wkbGeometryCollection: "GeometryCollection",
+}
+
+var errTruncatedWKB = errors.New("truncated WKB geometry")
+
+// convertGeometry decodes PostGIS geometry and geography values, which are read as hex encoded EWKB, into GeoJSON
+// geometry objects. Values that can't be decoded, such as curves, are sent as is.
+func convertGeometry(p *Postgres, v interface{}) interface{} {
+	s, ok := v.(string)
+	if !ok {
+		return v
+	}
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return v
+	}
+
+	g, err := decodeEWKB(b)
+	if err != nil {
+		return v
+	}
+	return g
+}
+
+// pointLatLon returns the coordinates of a GeoJSON point as lat and lon properties. Other values are returned as
+// is.
+func pointLatLon(v interface{}) interface{} {
+	g, ok := v.(map[string]interface{})
+	if !ok || g["type"] != "Point" {
+		return v
+	}
+	coordinates, ok := g["coordinates"].([]interface{})
+	if !ok || len(coordinates) < 2 {
+		return v
+	}
+	return map[string]interface{}{"lon": coordinates[0], "lat": coordinates[1]}
+}
+
+// decodeEWKB decodes a geometry in PostGIS' extended well-known binary format, which is also a superset of the ISO
+// format, into a GeoJSON geometry. M coordinates are dropped and the SRID is ignored, since GeoJSON supports
+// neither.
+func decodeEWKB(b []byte) (map[string]interface{}, error) {
+	r := &wkbReader{r: bytes.NewReader(b)}
+	g, err := r.readGeometry()
+	if err != nil {
+		return nil, err
+	}
+	if r.r.Len() > 0 {
+		return nil, errors.New("trailing bytes after WKB geometry")
+	}
+	return g, nil
+}
+
+type wkbReader struct {
+	r     *bytes.Reader
+	order binary.ByteOrder
+}
+
+func (r *wkbReader) readGeometry() (map[string]interface{}, error) {
+	// the members of multi geometries and collections declare their own byte order
+	defer func(order binary.ByteOrder) { r.order = order }(r.order)
+
+	order, err := r.r.ReadByte()
+	if err != nil {
+		return nil, errTruncatedWKB
+	}
+	switch order {
+	case 0:
+		r.order = binary.BigEndian
+	case 1:
+		r.order = binary.LittleEndian
+	default:
+		return nil, fmt.Errorf("invalid WKB byte order %d", order)
+	}
+
+	typ, err := r.readUint32()
+	if err != nil {
+		return nil, err
+	}
+
+	hasZ, hasM := typ&ewkbZ != 0, typ&ewkbM != 0
+	if typ&ewkbSRID != 0 {
+		if _, err := r.readUint32(); err != nil {
+			return nil, err
+		}
+	}
+	typ &^= ewkbZ | ewkbM | ewkbSRID
+
+	// ISO WKB encodes dimensions in the thousands of the type
+	switch typ / 1000 {
+	case 1:
+		hasZ = true
+	case 2:
+		hasM = true
+	case 3:
+		hasZ, hasM = true, true
+	}
+	typ %= 1000
+
+	name, ok := geoJSONTypes[typ]
+	if !ok {
+		return nil, fmt.Errorf("unsupported WKB geometry type %d", typ)
+	}
+	dims := 2
+	if hasZ {
+		dims++
+	}
+	if hasM {
+		dims++
+	}
+
+	g := map[string]interface{}{"type": name}
+	switch typ {
+	case wkbPoint:
+		point, err := r.readPoint(dims, hasZ)
+		if err != nil {
+			return nil, err
+		}
+		g["coordinates"] = point
+	case wkbLineString:
+		line, err := r.readPoints(dims, hasZ)
+		if err != nil {
+			return nil, err
+		}
+		g["coordinates"] = line
+	case wkbPolygon:
+		polygon, err := r.readRings(dims, hasZ)
+		if err != nil {
+			return nil, err
+		}
+		g["coordinates"] = polygon
+	case wkbMultiPoint, wkbMultiLineString, wkbMultiPolygon:
+		members, err := r.readMembers()
+		if err != nil {
+			return nil, err
+		}
+		coordinates := make([]interface{}, 0, len(members))
+		for _, m := range members {
+			coordinates = append(coordinates, m.(map[string]interface{})["coordinates"])
+		}
+		g["coordinates"] = coordinates
+	case wkbGeometryCollection:
+		members, err := r.readMembers()
+		if err != nil {
+			return nil, err
+		}
+		g["geometries"] = members
+	}
+
+	return g, nil
+}
+
+// readMembers reads the geometries of a multi geometry or collection, each one has its own header.
+func (r *wkbReader) readMembers() ([]interface{}, error) {
+	n, err := r.readUint32()
+	if err != nil {
+		return nil, err
+	}
+
+	members := []interface{}{}
+	for i := uint32(0); i < n; i++ {
+		m, err := r.readGeometry()
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+func (r *wkbReader) readRings(dims int, hasZ bool) ([]interface{}, error) {
+	n, err := r.readUint32()
+	if err != nil {
+		return nil, err
+	}
+
+	rings := []interface{}{}
+	for i := uint32(0); i < n; i++ {
+		ring, err := r.readPoints(dims, hasZ)
+		if err != nil {
+			return nil, err
+		}
+		rings = append(rings, ring)
+	}
+	return rings, nil
+}
+
+func (r *wkbReader) readPoints(dims int, hasZ bool) ([]interface{}, error) {
+	n, err := r.readUint32()
+	if err != nil {
+		return nil, err
+	}
+	// every point takes at least 16 bytes, so the count can't exceed what is left
+	if int64(n)*16 > int64(r.r.Len()) {
+		return nil, errTruncatedWKB
+	}
+
+	points := make([]interface{}, 0, n)
+	for i := uint32(0); i < n; i++ {
+		point, err := r.readPoint(dims, hasZ)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, point)
+	}
+	return points, nil
+}
+
+// readPoint reads the coordinates of a point, keeping x, y and z. Empty points are encoded with NaN coordinates and
+// returned without any, other NaN and infinite coordinates are returned as special values like floats.
+func (r *wkbReader) readPoint(dims int, hasZ bool) ([]interface{}, error) {
+	coordinates := make([]interface{}, 0, 3)
+	empty := true
+	for i := 0; i < dims; i++ {
+		bits, err := r.readUint64()
+		if err != nil {
+			return nil, err
+		}
+		f := math.Float64frombits(bits)
+		if !math.IsNaN(f) {
+			empty = false
+		}
+		if i < 2 || (i == 2 && hasZ) {
+			coordinates = append(coordinates, convertFloat(nil, f))
+		}
+	}
+
+	if empty {
+		return []interface{}{}, nil
+	}
+	return coordinates, nil
+}
+
+func (r *wkbReader) readUint32() (uint32, error) {
+	var b [4]byte
+	if n, err := r.r.Read(b[:]); err != nil || n < len(b) {
+		return 0, errTruncatedWKB
+	}
+	return r.order.Uint32(b[:]), nil
+}
+
+func (r *wkbReader) readUint64() (uint64, error) {
+	var b [8]byte
+	if n, err := r.r.Read(b[:]); err != nil || n < len(b) {
+		return 0, errTruncatedWKB
+	}
+	return r.order.Uint64(b[:]), nil
+}
@@ -0,0 +1,190 @@
+package postgres
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"github.com/segment-sources/source-postgres/sqlsource/domain"
+)
+
+// coordinates builds the expected coordinates of a decoded geometry.
+func coordinates(values ...interface{}) []interface{} {
+	return values
+}
+
+func TestDecodeEWKB(t *testing.T) {
+	square := coordinates(coordinates(0.0, 0.0), coordinates(1.0, 0.0), coordinates(1.0, 1.0), coordinates(0.0, 0.0))
+
+	tests := []struct {
+		name     string
+		hex      string
+		expected map[string]interface{}
+	}{
+		{
+			name:     "point with SRID",
+			hex:      "0101000020E6100000000000000000F03F0000000000000040",
+			expected: map[string]interface{}{"type": "Point", "coordinates": coordinates(1.0, 2.0)},
+		},
+		{
+			name:     "point with Z flag",
+			hex:      "0101000080000000000000F03F00000000000000400000000000000840",
+			expected: map[string]interface{}{"type": "Point", "coordinates": coordinates(1.0, 2.0, 3.0)},
+		},
+		{
+			name:     "ISO point Z",
+			hex:      "01E9030000000000000000F03F00000000000000400000000000000840",
+			expected: map[string]interface{}{"type": "Point", "coordinates": coordinates(1.0, 2.0, 3.0)},
+		},
+		{
+			name:     "point with M flag",
+			hex:      "0101000040000000000000F03F00000000000000400000000000001040",
+			expected: map[string]interface{}{"type": "Point", "coordinates": coordinates(1.0, 2.0)},
+		},
+		{
+			name:     "ISO point ZM",
+			hex:      "01B90B0000000000000000F03F000000000000004000000000000008400000000000001040",
+			expected: map[string]interface{}{"type": "Point", "coordinates": coordinates(1.0, 2.0, 3.0)},
+		},
+		{
+			name:     "big endian point",
+			hex:      "00000000013FF00000000000004000000000000000",
+			expected: map[string]interface{}{"type": "Point", "coordinates": coordinates(1.0, 2.0)},
+		},
+		{
+			name:     "empty point",
+			hex:      "0101000000000000000000F87F000000000000F87F",
+			expected: map[string]interface{}{"type": "Point", "coordinates": []interface{}{}},
+		},
+		{
+			name: "line string",
+			hex:  "01020000000200000000000000000000000000000000000000000000000000F03F000000000000F03F",
+			expected: map[string]interface{}{
+				"type":        "LineString",
+				"coordinates": coordinates(coordinates(0.0, 0.0), coordinates(1.0, 1.0)),
+			},
+		},
+		{
+			name: "polygon",
+			hex: "0103000000010000000400000000000000000000000000000000000000000000000000F03F00000000000000000000" +
+				"00000000F03F000000000000F03F00000000000000000000000000000000",
+			expected: map[string]interface{}{"type": "Polygon", "coordinates": coordinates(square)},
+		},
+		{
+			name: "multi point",
+			hex: "0104000000020000000101000000000000000000F03F000000000000004001010000000000000000000840000000" +
+				"0000001040",
+			expected: map[string]interface{}{
+				"type":        "MultiPoint",
+				"coordinates": coordinates(coordinates(1.0, 2.0), coordinates(3.0, 4.0)),
+			},
+		},
+		{
+			name: "multi line string",
+			hex: "01050000000100000001020000000200000000000000000000000000000000000000000000000000F03F00000000" +
+				"0000F03F",
+			expected: map[string]interface{}{
+				"type":        "MultiLineString",
+				"coordinates": coordinates(coordinates(coordinates(0.0, 0.0), coordinates(1.0, 1.0))),
+			},
+		},
+		{
+			name: "multi polygon",
+			hex: "0106000000010000000103000000010000000400000000000000000000000000000000000000000000000000F03F" +
+				"0000000000000000000000000000F03F000000000000F03F00000000000000000000000000000000",
+			expected: map[string]interface{}{"type": "MultiPolygon", "coordinates": coordinates(coordinates(square))},
+		},
+		{
+			name: "geometry collection with members of both byte orders",
+			hex: "0107000020E6100000020000000101000000000000000000F03F00000000000000400000000002000000020000000000" +
+				"00000000000000000000003FF00000000000003FF0000000000000",
+			expected: map[string]interface{}{
+				"type": "GeometryCollection",
+				"geometries": coordinates(
+					map[string]interface{}{"type": "Point", "coordinates": coordinates(1.0, 2.0)},
+					map[string]interface{}{
+						"type":        "LineString",
+						"coordinates": coordinates(coordinates(0.0, 0.0), coordinates(1.0, 1.0)),
+					},
+				),
+			},
+		},
+		{
+			name:     "point with a NaN coordinate",
+			hex:      "0101000000000000000000F87F0000000000000040",
+			expected: map[string]interface{}{"type": "Point", "coordinates": coordinates(specialNaN, 2.0)},
+		},
+		{
+			name: "line string with an infinite coordinate",
+			hex:  "0102000000020000000000000000000000000000000000F07F000000000000F03F000000000000F03F",
+			expected: map[string]interface{}{
+				"type":        "LineString",
+				"coordinates": coordinates(coordinates(0.0, specialInfinity), coordinates(1.0, 1.0)),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		b, err := hex.DecodeString(test.hex)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		actual, err := decodeEWKB(b)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestDecodeEWKBErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		hex  string
+	}{
+		{"truncated point", "0101000000000000000000F03F00000000"},
+		{"trailing bytes", "0101000000000000000000F03F000000000000004000"},
+		{"invalid byte order", "0201000000000000000000F03F0000000000000040"},
+		{"circular string", "010800000000000000"},
+		{"point count larger than the geometry", "0102000000FFFFFFFF"},
+		{"empty", ""},
+	}
+
+	for _, test := range tests {
+		b, err := hex.DecodeString(test.hex)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if g, err := decodeEWKB(b); err == nil {
+			t.Errorf("%s: expected an error, got %v", test.name, g)
+		}
+	}
+}
+
+func TestTransformGeometry(t *testing.T) {
+	p := &Postgres{types: map[string]map[string]string{
+		"public.places": {"location": "geometry", "area": "geography", "point": "geometry"},
+	}}
+	table := &domain.Table{SchemaName: "public", TableName: "places", LatLon: []string{"point"}}
+
+	row := p.Transform(table, map[string]interface{}{
+		"location": "0101000000000000000000F87F0000000000000040",
+		"area":     "010800000000000000",
+		"point":    "0101000020E6100000000000000000F03F0000000000000040",
+	})
+
+	expected := map[string]interface{}{
+		"location": map[string]interface{}{"type": "Point", "coordinates": coordinates(nil, 2.0)},
+		"area":     "010800000000000000",
+		"point":    map[string]interface{}{"lon": 1.0, "lat": 2.0},
+	}
+	if !reflect.DeepEqual(row, expected) {
+		t.Errorf("expected %v, got %v", expected, row)
+	}
+	if table.State.SubstitutedValues != 1 {
+		t.Errorf("expected 1 substituted value, got %d", table.State.SubstitutedValues)
+	}
+}
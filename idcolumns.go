@@ -0,0 +1,122 @@
+package postgres
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/segment-sources/source-postgres/sqlsource/domain"
+)
+
+// ValidateIDColumns checks that the table's id_columns are NOT NULL and hold every key column of a unique index, so
+// that they identify its rows. Views have no indexes at all, so their id_columns are left to the user like their
+// primary_keys. The columns of materialized views are never marked NOT NULL, so they can't have id_columns.
+func (p *Postgres) ValidateIDColumns(t *domain.Table) error {
+	if len(t.IDColumns) == 0 || t.Kind == domain.KindQuery || t.Kind == domain.KindView {
+		return nil
+	}
+
+	query := `
+		WITH id AS (SELECT json_array_elements_text($2::json) AS name)
+		SELECT NOT EXISTS (
+		    SELECT 1 FROM id
+		      LEFT JOIN pg_catalog.pg_attribute a ON a.attrelid = c.oid AND a.attname = id.name AND NOT a.attisdropped
+		    WHERE a.attnotnull IS NOT TRUE),
+		  EXISTS (
+		    SELECT 1 FROM pg_catalog.pg_index i
+		    WHERE i.indrelid = c.oid AND i.indisunique AND i.indisvalid AND i.indpred IS NULL AND i.indexprs IS NULL
+		      AND NOT EXISTS (
+		        SELECT 1 FROM pg_catalog.pg_attribute a
+		        WHERE a.attrelid = c.oid AND a.attnum = ANY ((i.indkey::int2[])[0:i.indnkeyatts - 1])
+		          AND a.attname NOT IN (SELECT name FROM id)))
+		FROM pg_catalog.pg_class c WHERE c.oid = ($1::text)::regclass`
+
+	rows, err := p.query(query, fmt.Sprintf("%q.%q", t.SchemaName, t.TableName), idColumnsArg(t))
+	if err != nil {
+		return fmt.Errorf("%s.%s: %v", t.SchemaName, t.TableName, err)
+	}
+	defer rows.Close()
+
+	var notNull, unique bool
+	for rows.Next() {
+		if err := rows.Scan(&notNull, &unique); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if !notNull {
+		return fmt.Errorf("%s.%s: id columns %v are not all NOT NULL", t.SchemaName, t.TableName, t.IDColumns)
+	}
+	if !unique {
+		return fmt.Errorf("%s.%s: id columns %v don't hold the columns of a unique index", t.SchemaName,
+			t.TableName, t.IDColumns)
+	}
+	return nil
+}
+
+// StreamsIDColumns tells whether the changes streamed for updates and deletes of the table carry the values of its
+// id_columns. Postgres only logs the columns of the replica identity of the old row, which is its primary key by
+// default, so the ID columns have to be part of it or the table has to be REPLICA IDENTITY FULL. The changes of
+// partitioned tables come from their partitions, which have their own replica identity, while views never change.
+func (p *Postgres) StreamsIDColumns(t *domain.Table) (bool, error) {
+	switch {
+	case len(t.IDColumns) == 0, t.Kind == domain.KindQuery, t.Kind == domain.KindView,
+		t.Kind == domain.KindMaterializedView:
+		return true, nil
+	}
+
+	relations := []string{fmt.Sprintf("%q.%q", t.SchemaName, t.TableName)}
+	if t.Kind == domain.KindPartitioned {
+		leaves, err := p.leafPartitions(t)
+		if err != nil {
+			return false, err
+		}
+		relations = relations[:0]
+		for _, leaf := range leaves {
+			relations = append(relations, fmt.Sprintf("%q.%q", leaf.SchemaName, leaf.TableName))
+		}
+	}
+
+	query := `
+		WITH id AS (SELECT json_array_elements_text($2::json) AS name)
+		SELECT c.relreplident = 'f' OR NOT EXISTS (
+		    SELECT 1 FROM id WHERE id.name NOT IN (
+		        SELECT a.attname FROM pg_catalog.pg_index i
+		          INNER JOIN pg_catalog.pg_attribute a
+		            ON a.attrelid = i.indrelid AND a.attnum = ANY ((i.indkey::int2[])[0:i.indnkeyatts - 1])
+		        WHERE i.indrelid = c.oid
+		          AND (c.relreplident = 'd' AND i.indisprimary OR c.relreplident = 'i' AND i.indisreplident)))
+		FROM pg_catalog.pg_class c WHERE c.oid = ($1::text)::regclass`
+
+	for _, relation := range relations {
+		rows, err := p.query(query, relation, idColumnsArg(t))
+		if err != nil {
+			return false, err
+		}
+
+		covered := false
+		for rows.Next() {
+			if err := rows.Scan(&covered); err != nil {
+				rows.Close()
+				return false, err
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return false, err
+		}
+		if !covered {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// idColumnsArg returns the table's ID columns as the JSON array the queries above expand.
+func idColumnsArg(t *domain.Table) string {
+	b, _ := json.Marshal(t.IDColumns)
+	return string(b)
+}
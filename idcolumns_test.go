@@ -0,0 +1,59 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/segment-sources/source-postgres/sqlsource/domain"
+)
+
+func TestIDColumns(t *testing.T) {
+	db := testDB(t)
+	defer db.Close()
+
+	statements := []string{
+		`CREATE SCHEMA id_columns_test`,
+		`CREATE TABLE id_columns_test.accounts (id int PRIMARY KEY, external_id text NOT NULL UNIQUE, region text NOT NULL,
+			email text UNIQUE, UNIQUE (region, external_id))`,
+		`CREATE TABLE id_columns_test.full_accounts (id int PRIMARY KEY, external_id text NOT NULL UNIQUE)`,
+		`ALTER TABLE id_columns_test.full_accounts REPLICA IDENTITY FULL`,
+	}
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer db.Exec(`DROP SCHEMA id_columns_test CASCADE`)
+
+	p := &Postgres{Connection: db}
+
+	tests := []struct {
+		table     string
+		idColumns []string
+		valid     bool
+		streamed  bool
+	}{
+		{"accounts", []string{"external_id"}, true, false},
+		{"accounts", []string{"id"}, true, true},
+		{"accounts", []string{"id", "region"}, true, false},
+		{"accounts", []string{"region", "external_id"}, true, false},
+		{"accounts", []string{"region"}, false, false},
+		{"accounts", []string{"email"}, false, false},
+		{"full_accounts", []string{"external_id"}, true, true},
+	}
+
+	for _, test := range tests {
+		table := &domain.Table{SchemaName: "id_columns_test", TableName: test.table, IDColumns: test.idColumns}
+
+		if err := p.ValidateIDColumns(table); (err == nil) != test.valid {
+			t.Errorf("%s %v: expected valid %v, got %v", test.table, test.idColumns, test.valid, err)
+		}
+
+		streamed, err := p.StreamsIDColumns(table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if streamed != test.streamed {
+			t.Errorf("%s %v: expected streamed %v, got %v", test.table, test.idColumns, test.streamed, streamed)
+		}
+	}
+}
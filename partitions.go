@@ -0,0 +1,60 @@
+package postgres
+
+import (
+	"fmt"
+
+	"github.com/segment-sources/source-postgres/sqlsource/domain"
+)
+
+type partition struct {
+	SchemaName string `db:"schema_name"`
+	TableName  string `db:"table_name"`
+}
+
+// Partitions returns the leaf partitions of a partitioned table as qualified, quoted names.
+func (p *Postgres) Partitions(t *domain.Table) ([]string, error) {
+	leaves, err := p.leafPartitions(t)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]string, 0, len(leaves))
+	for _, leaf := range leaves {
+		res = append(res, fmt.Sprintf("%q.%q", leaf.SchemaName, leaf.TableName))
+	}
+	return res, nil
+}
+
+// leafPartitions walks the partition hierarchy of the table down to the partitions that hold rows.
+func (p *Postgres) leafPartitions(t *domain.Table) ([]*partition, error) {
+	query := `
+    with recursive tree as (SELECT i.inhrelid AS oid
+        FROM pg_catalog.pg_inherits i
+        WHERE i.inhparent = ($1::text)::regclass
+      UNION ALL SELECT i.inhrelid
+        FROM pg_catalog.pg_inherits i INNER JOIN tree ON i.inhparent = tree.oid)
+
+    select _s.nspname as schema_name, _t.relname as table_name
+        FROM tree INNER JOIN pg_catalog.pg_class _t ON _t.oid = tree.oid
+          INNER JOIN pg_catalog.pg_namespace _s ON _t.relnamespace = _s.oid
+        WHERE _t.relkind <> 'p'
+        ORDER BY 1, 2;
+    `
+
+	rows, err := p.query(query, fmt.Sprintf("%q.%q", t.SchemaName, t.TableName))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []*partition{}
+	for rows.Next() {
+		leaf := &partition{}
+		if err := rows.StructScan(leaf); err != nil {
+			return nil, err
+		}
+		res = append(res, leaf)
+	}
+
+	return res, rows.Err()
+}
@@ -0,0 +1,71 @@
+package postgres
+
+import (
+	"fmt"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/segment-sources/source-postgres/sqlsource/domain"
+)
+
+// SplitRanges splits the table on its leading primary key column. The bounds are taken from the column's histogram
+// collected by ANALYZE if there is one, or interpolated between the column's minimum and maximum otherwise, which
+// only works for numeric and date/time columns. Bounds are returned in their text representation.
+func (p *Postgres) SplitRanges(t *domain.Table, n int) ([]interface{}, error) {
+	column := t.KeyColumns()[0]
+
+	histogram, err := p.queryStrings(`
+		SELECT b
+		FROM pg_catalog.pg_stats s, unnest(s.histogram_bounds::text::text[]) b
+		WHERE s.schemaname = $1 AND s.tablename = $2 AND s.attname = $3 AND NOT s.inherited`,
+		t.SchemaName, t.TableName, column)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(histogram) > n {
+		bounds := make([]interface{}, 0, n-1)
+		for i := 1; i < n; i++ {
+			bounds = append(bounds, histogram[i*len(histogram)/n])
+		}
+		return bounds, nil
+	}
+
+	query := fmt.Sprintf(`
+		SELECT b::text FROM (
+			SELECT DISTINCT m.lo + (m.hi - m.lo) * i / $1 AS b
+			FROM (SELECT min("%[1]s") AS lo, max("%[1]s") AS hi FROM %[2]s) m, generate_series(1, $1 - 1) i
+			WHERE m.lo IS NOT NULL
+		) bounds ORDER BY bounds.b`, column, relation(t))
+
+	interpolated, err := p.queryStrings(query, n)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"table": t.TableName, "schema": t.SchemaName, "column": column}).
+			Warnf("Can't split table into key ranges, run ANALYZE to collect statistics: %v", err)
+		return nil, nil
+	}
+
+	bounds := make([]interface{}, 0, len(interpolated))
+	for _, b := range interpolated {
+		bounds = append(bounds, b)
+	}
+	return bounds, nil
+}
+
+func (p *Postgres) queryStrings(query string, args ...interface{}) ([]string, error) {
+	rows, err := p.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []string{}
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		res = append(res, s)
+	}
+
+	return res, rows.Err()
+}
@@ -0,0 +1,94 @@
+package postgres
+
+import (
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/jmoiron/sqlx"
+)
+
+// txRows closes the transaction a query was run in, if any, together with its rows.
+type txRows struct {
+	*sqlx.Rows
+	tx *sqlx.Tx
+}
+
+func (r *txRows) Close() error {
+	err := r.Rows.Close()
+	if r.tx == nil {
+		return err
+	}
+	if rbErr := r.tx.Rollback(); err == nil {
+		err = rbErr
+	}
+	return err
+}
+
+// exportSnapshot opens the transaction whose snapshot is shared by every query of the run. The transaction is kept
+// open until the process exits, since the snapshot can only be imported while it is.
+func (p *Postgres) exportSnapshot() error {
+	tx, err := p.Connection.Beginx()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("SET TRANSACTION ISOLATION LEVEL REPEATABLE READ, READ ONLY"); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.QueryRowx("SELECT pg_export_snapshot()").Scan(&p.snapshot); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	p.snapshotTx = tx
+	logrus.WithField("snapshot", p.snapshot).Info("Exported snapshot")
+
+	return nil
+}
+
+// beginSnapshot starts a transaction that sees the database as of the exported snapshot.
+func (p *Postgres) beginSnapshot() (*sqlx.Tx, error) {
+	tx, err := p.Connection.Beginx()
+	if err != nil {
+		return nil, err
+	}
+
+	statements := []string{
+		"SET TRANSACTION ISOLATION LEVEL REPEATABLE READ, READ ONLY",
+		"SET TRANSACTION SNAPSHOT '" + strings.Replace(p.snapshot, "'", "''", -1) + "'",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	return tx, nil
+}
+
+// query runs a query in the exported snapshot if there is one.
+func (p *Postgres) query(query string, args ...interface{}) (*txRows, error) {
+	if p.snapshotTx == nil {
+		rows, err := p.Connection.Queryx(query, args...)
+		if err != nil {
+			return nil, err
+		}
+		return &txRows{Rows: rows}, nil
+	}
+
+	tx, err := p.beginSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Queryx(query, args...)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return &txRows{Rows: rows, tx: tx}, nil
+}
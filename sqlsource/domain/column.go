@@ -0,0 +1,24 @@
+package domain
+
+type Column struct {
+	Schema       string
+	Table        string
+	Name         string
+	IsPrimaryKey bool
+
+	// KeyPosition is the position of primary key columns in the key, starting at 1. Key columns are recorded in
+	// this order, or in the order they are added if it's not set.
+	KeyPosition int
+
+	// Metadata describes the column's type, it is optional.
+	Metadata *ColumnMetadata
+}
+
+// ColumnMetadata is the type information of a column recorded in the schema for downstream tooling.
+type ColumnMetadata struct {
+	DataType string `json:"data_type"`
+	UDTName  string `json:"udt_name"`
+	Nullable bool   `json:"nullable"`
+	Default  string `json:"default,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
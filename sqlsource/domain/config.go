@@ -0,0 +1,33 @@
+package domain
+
+// Representations of special values, such as NaN floats and infinite timestamps, that can't be sent as is.
+const (
+	// SpecialValuesNull sends special values as null.
+	SpecialValuesNull = "null"
+	// SpecialValuesString sends special values as strings, such as "NaN" or "infinity".
+	SpecialValuesString = "string"
+)
+
+type Config struct {
+	Init         bool
+	Driver       string
+	Hostname     string `valid:"host"`
+	Port         string `valid:"port"`
+	Username     string
+	Password     string
+	Database     string
+	ExtraOptions []string
+
+	// Snapshot makes every table scan of the run read from the same database snapshot.
+	Snapshot bool
+
+	// NumericAsFloat converts arbitrary precision numbers to floats instead of decimal strings.
+	NumericAsFloat bool
+
+	// SpecialValues is how values such as NaN floats and infinite timestamps are represented, SpecialValuesNull or
+	// SpecialValuesString.
+	SpecialValues string
+
+	// ReplicationSlot is the name of the logical replication slot used in streaming mode.
+	ReplicationSlot string
+}
@@ -3,6 +3,8 @@ package domain
 import (
 	"encoding/json"
 	"io"
+	"math"
+	"sort"
 	"sync"
 )
 
@@ -22,9 +24,21 @@ func NewDescriptionFromReader(r io.Reader) (*Description, error) {
 	if err := json.NewDecoder(r).Decode(&d.schemas); err != nil {
 		return nil, err
 	}
+	for schemaName, tables := range d.schemas {
+		for tableName, t := range tables {
+			t.SchemaName = schemaName
+			t.TableName = tableName
+		}
+	}
 	return d, nil
 }
 
+// Table looks up a table by schema and name.
+func (d *Description) Table(schema, table string) (*Table, bool) {
+	t, ok := d.schemas[schema][table]
+	return t, ok
+}
+
 func (d *Description) SchemaCount() int {
 	return len(d.schemas)
 }
@@ -48,10 +62,39 @@ func (d *Description) AddColumn(c *Column) {
 	}
 
 	if c.IsPrimaryKey {
-		table.PrimaryKeys = append(table.PrimaryKeys, c.Name)
+		position := c.KeyPosition
+		if position == 0 {
+			position = math.MaxInt32
+		}
+		// columns are usually added in table order, which doesn't have to be the order of the key
+		i := sort.SearchInts(table.keyPositions, position+1)
+		table.PrimaryKeys = append(table.PrimaryKeys, "")
+		copy(table.PrimaryKeys[i+1:], table.PrimaryKeys[i:])
+		table.PrimaryKeys[i] = c.Name
+		table.keyPositions = append(table.keyPositions, 0)
+		copy(table.keyPositions[i+1:], table.keyPositions[i:])
+		table.keyPositions[i] = position
 	}
 
 	table.Columns = append(table.Columns, c.Name)
+
+	if c.Metadata != nil {
+		if table.ColumnMetadata == nil {
+			table.ColumnMetadata = map[string]*ColumnMetadata{}
+		}
+		table.ColumnMetadata[c.Name] = c.Metadata
+	}
+}
+
+// AddTable adds a table, replacing any table with the same schema and name.
+func (d *Description) AddTable(t *Table) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if _, ok := d.schemas[t.SchemaName]; !ok {
+		d.schemas[t.SchemaName] = map[string]*Table{}
+	}
+	d.schemas[t.SchemaName][t.TableName] = t
 }
 
 func (d *Description) Save(w io.Writer) error {
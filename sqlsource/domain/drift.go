@@ -0,0 +1,256 @@
+package domain
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Drift policies, telling how differences between the saved schema and the database are handled when merging.
+const (
+	// DriftAdd adds new tables and columns to the schema, and removes the ones that no longer exist.
+	DriftAdd = "add"
+	// DriftIgnore reports the differences but leaves the schema as it is.
+	DriftIgnore = "ignore"
+	// DriftFail reports the differences and fails the merge if there are any.
+	DriftFail = "fail"
+)
+
+// Drift lists the differences between a saved schema and the database. Tables are named "schema.table".
+type Drift struct {
+	AddedTables    []string
+	RemovedTables  []string
+	AddedColumns   map[string][]string
+	RemovedColumns map[string][]string
+	// ChangedIdentities describes how the rows of a table are identified in the database, for the tables where it
+	// differs from the saved schema.
+	ChangedIdentities map[string]string
+}
+
+// Empty returns true if the schema matches the database.
+func (d *Drift) Empty() bool {
+	return len(d.AddedTables) == 0 && len(d.RemovedTables) == 0 && len(d.AddedColumns) == 0 &&
+		len(d.RemovedColumns) == 0 && len(d.ChangedIdentities) == 0
+}
+
+func (d *Drift) String() string {
+	changes := []string{}
+	for _, t := range d.AddedTables {
+		changes = append(changes, fmt.Sprintf("table %s added", t))
+	}
+	for _, t := range d.RemovedTables {
+		changes = append(changes, fmt.Sprintf("table %s removed", t))
+	}
+	for _, t := range sortedKeys(d.AddedColumns) {
+		changes = append(changes, fmt.Sprintf("columns %s added to %s", strings.Join(d.AddedColumns[t], ", "), t))
+	}
+	for _, t := range sortedKeys(d.RemovedColumns) {
+		changes = append(changes, fmt.Sprintf("columns %s removed from %s", strings.Join(d.RemovedColumns[t], ", "), t))
+	}
+	identities := []string{}
+	for t, identity := range d.ChangedIdentities {
+		identities = append(identities, fmt.Sprintf("%s identified by %s", t, identity))
+	}
+	sort.Strings(identities)
+	changes = append(changes, identities...)
+	return strings.Join(changes, "; ")
+}
+
+// Merge compares the saved description with the live one described from the database, and returns the
+// description resulting from the policy along with the differences found.
+//
+// Columns recorded in the column metadata of a saved table but missing from its columns were removed by hand, and
+// stay excluded. Tables without column metadata don't tell excluded columns apart from new ones, so none of their
+// columns are added. Query tables are not in the database and are kept as they are.
+//
+// The identity of a table, its primary_keys, identity and identity_index, is compared with the database unless the
+// database has none to offer, as for views whose keys are declared by hand. The policy adopts the identity of the
+// database, disabling tables that are left to be identified by the location of their rows.
+func Merge(saved, live *Description, policy string) (*Description, *Drift, error) {
+	switch policy {
+	case DriftAdd, DriftIgnore, DriftFail:
+	default:
+		return nil, nil, fmt.Errorf("unknown drift policy %q, expected %q, %q or %q", policy, DriftAdd, DriftIgnore,
+			DriftFail)
+	}
+
+	drift := &Drift{
+		AddedColumns:      map[string][]string{},
+		RemovedColumns:    map[string][]string{},
+		ChangedIdentities: map[string]string{},
+	}
+	merged := NewDescription()
+
+	for t := range saved.Iter() {
+		name := fmt.Sprintf("%s.%s", t.SchemaName, t.TableName)
+		if t.Kind == KindQuery {
+			merged.AddTable(t)
+			continue
+		}
+
+		l, ok := live.Table(t.SchemaName, t.TableName)
+		if !ok {
+			drift.RemovedTables = append(drift.RemovedTables, name)
+			if policy != DriftAdd {
+				merged.AddTable(t)
+			}
+			continue
+		}
+
+		added, removed := diffColumns(t, l)
+		if len(added) > 0 {
+			drift.AddedColumns[name] = added
+		}
+		if len(removed) > 0 {
+			drift.RemovedColumns[name] = removed
+		}
+		changedIdentity := l.Identity != "" && !sameIdentity(t, l)
+		if changedIdentity {
+			drift.ChangedIdentities[name] = identityText(l)
+		}
+		if policy == DriftAdd {
+			// the saved description is left untouched
+			mt := *t
+			mergeColumns(&mt, l, removed)
+			if changedIdentity {
+				mergeIdentity(&mt, l)
+			}
+			t = &mt
+		}
+		merged.AddTable(t)
+	}
+
+	for l := range live.Iter() {
+		if _, ok := saved.Table(l.SchemaName, l.TableName); ok {
+			continue
+		}
+		drift.AddedTables = append(drift.AddedTables, fmt.Sprintf("%s.%s", l.SchemaName, l.TableName))
+		if policy == DriftAdd {
+			merged.AddTable(l)
+		}
+	}
+
+	sort.Strings(drift.AddedTables)
+	sort.Strings(drift.RemovedTables)
+
+	if policy == DriftFail && !drift.Empty() {
+		return nil, drift, fmt.Errorf("schema drift: %s", drift)
+	}
+	return merged, drift, nil
+}
+
+// diffColumns returns the columns of the live table that are new to the saved table, and the columns of the saved
+// table that no longer exist.
+func diffColumns(saved, live *Table) (added, removed []string) {
+	liveColumns := map[string]bool{}
+	for _, c := range live.Columns {
+		liveColumns[c] = true
+	}
+	for _, c := range saved.Columns {
+		if !liveColumns[c] {
+			removed = append(removed, c)
+		}
+	}
+
+	if saved.ColumnMetadata == nil {
+		return nil, removed
+	}
+	for _, c := range live.Columns {
+		if _, known := saved.ColumnMetadata[c]; !known {
+			added = append(added, c)
+		}
+	}
+	return added, removed
+}
+
+// mergeColumns removes the columns that no longer exist from the saved table and appends the new ones, refreshing
+// the column metadata. Columns excluded by hand stay excluded.
+func mergeColumns(saved, live *Table, removed []string) {
+	gone := map[string]bool{}
+	for _, c := range removed {
+		gone[c] = true
+	}
+
+	columns := []string{}
+	for _, c := range saved.Columns {
+		if !gone[c] {
+			columns = append(columns, c)
+		}
+	}
+	if saved.ColumnMetadata != nil {
+		for _, c := range live.Columns {
+			if _, known := saved.ColumnMetadata[c]; !known {
+				columns = append(columns, c)
+			}
+		}
+	}
+
+	saved.Columns = columns
+	saved.ColumnMetadata = live.ColumnMetadata
+}
+
+// sameIdentity returns true if both tables are identified the same way.
+func sameIdentity(saved, live *Table) bool {
+	if identity(saved) != identity(live) || saved.IdentityIndex != live.IdentityIndex {
+		return false
+	}
+	if len(saved.PrimaryKeys) != len(live.PrimaryKeys) {
+		return false
+	}
+	for i := range saved.PrimaryKeys {
+		if saved.PrimaryKeys[i] != live.PrimaryKeys[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// identity returns the identity strategy of the table, IdentityPrimaryKey if it's not set.
+func identity(t *Table) string {
+	if t.Identity == "" {
+		return IdentityPrimaryKey
+	}
+	return t.Identity
+}
+
+// identityText describes how the rows of the table are identified.
+func identityText(t *Table) string {
+	switch identity(t) {
+	case IdentityRowLocation:
+		return "the location of its rows"
+	case IdentityUniqueIndex:
+		return fmt.Sprintf("unique index %s (%s)", t.IdentityIndex, strings.Join(t.PrimaryKeys, ", "))
+	}
+	return fmt.Sprintf("primary key (%s)", strings.Join(t.PrimaryKeys, ", "))
+}
+
+// mergeIdentity adopts the identity of the live table, adding the key columns that are missing from the columns of
+// the saved table. Tables that are left to be identified by the location of their rows are disabled, as they are by
+// Describe.
+func mergeIdentity(saved, live *Table) {
+	if identity(saved) != IdentityRowLocation && identity(live) == IdentityRowLocation {
+		saved.Disabled = true
+	}
+	saved.Identity = live.Identity
+	saved.IdentityIndex = live.IdentityIndex
+	saved.PrimaryKeys = live.PrimaryKeys
+
+	columns := map[string]bool{}
+	for _, c := range saved.Columns {
+		columns[c] = true
+	}
+	for _, pk := range live.PrimaryKeys {
+		if !columns[pk] {
+			saved.Columns = append(saved.Columns, pk)
+		}
+	}
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
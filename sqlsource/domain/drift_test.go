@@ -0,0 +1,181 @@
+package domain
+
+import (
+	"reflect"
+	"testing"
+)
+
+// metadata returns column metadata listing the columns.
+func metadata(columns ...string) map[string]*ColumnMetadata {
+	m := map[string]*ColumnMetadata{}
+	for _, c := range columns {
+		m[c] = &ColumnMetadata{DataType: "text", UDTName: "text"}
+	}
+	return m
+}
+
+func description(tables ...*Table) *Description {
+	d := NewDescription()
+	for _, t := range tables {
+		d.AddTable(t)
+	}
+	return d
+}
+
+func TestMergeColumns(t *testing.T) {
+	saved := description(
+		&Table{SchemaName: "public", TableName: "films", PrimaryKeys: []string{"id"},
+			Columns: []string{"id", "title", "len"}, ColumnMetadata: metadata("id", "title", "len", "secret")},
+		&Table{SchemaName: "public", TableName: "legacy", PrimaryKeys: []string{"id"}, Columns: []string{"id"}},
+		&Table{SchemaName: "public", TableName: "gone", PrimaryKeys: []string{"id"}, Columns: []string{"id"}},
+		&Table{SchemaName: "reporting", TableName: "totals", Kind: KindQuery, Query: "SELECT 1 AS id",
+			PrimaryKeys: []string{"id"}, Columns: []string{"id"}},
+	)
+	live := description(
+		&Table{SchemaName: "public", TableName: "films", PrimaryKeys: []string{"id"},
+			Identity: IdentityPrimaryKey, Columns: []string{"id", "title", "secret", "rating"},
+			ColumnMetadata: metadata("id", "title", "secret", "rating")},
+		&Table{SchemaName: "public", TableName: "legacy", PrimaryKeys: []string{"id"},
+			Identity: IdentityPrimaryKey, Columns: []string{"id", "name"}, ColumnMetadata: metadata("id", "name")},
+		&Table{SchemaName: "public", TableName: "new", PrimaryKeys: []string{"id"},
+			Identity: IdentityPrimaryKey, Columns: []string{"id"}, ColumnMetadata: metadata("id")},
+	)
+
+	merged, drift, err := Merge(saved, live, DriftAdd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &Drift{
+		AddedTables:       []string{"public.new"},
+		RemovedTables:     []string{"public.gone"},
+		AddedColumns:      map[string][]string{"public.films": {"rating"}},
+		RemovedColumns:    map[string][]string{"public.films": {"len"}},
+		ChangedIdentities: map[string]string{},
+	}
+	if !reflect.DeepEqual(drift, expected) {
+		t.Errorf("expected drift %+v, got %+v", expected, drift)
+	}
+
+	films, _ := merged.Table("public", "films")
+	if !reflect.DeepEqual(films.Columns, []string{"id", "title", "rating"}) {
+		t.Errorf("expected the excluded column to stay excluded, got %v", films.Columns)
+	}
+	if !reflect.DeepEqual(films.ColumnMetadata, metadata("id", "title", "secret", "rating")) {
+		t.Errorf("expected the column metadata of the database, got %v", films.ColumnMetadata)
+	}
+	if legacy, _ := merged.Table("public", "legacy"); !reflect.DeepEqual(legacy.Columns, []string{"id"}) {
+		t.Errorf("expected no column added to a table without column metadata, got %v", legacy.Columns)
+	}
+	if _, ok := merged.Table("public", "gone"); ok {
+		t.Error("expected the removed table to be removed")
+	}
+	if _, ok := merged.Table("public", "new"); !ok {
+		t.Error("expected the new table to be added")
+	}
+	if _, ok := merged.Table("reporting", "totals"); !ok {
+		t.Error("expected the query table to be kept")
+	}
+
+	if savedFilms, _ := saved.Table("public", "films"); !reflect.DeepEqual(savedFilms.Columns,
+		[]string{"id", "title", "len"}) {
+		t.Errorf("expected the saved description to be left untouched, got %v", savedFilms.Columns)
+	}
+}
+
+func TestMergeIdentity(t *testing.T) {
+	saved := description(
+		&Table{SchemaName: "public", TableName: "reordered", PrimaryKeys: []string{"a", "b"},
+			Columns: []string{"a", "b"}},
+		&Table{SchemaName: "public", TableName: "promoted", PrimaryKeys: []string{"code"},
+			Identity: IdentityUniqueIndex, IdentityIndex: "promoted_code_key", Columns: []string{"code"}},
+		&Table{SchemaName: "public", TableName: "dropped", PrimaryKeys: []string{"id"}, Columns: []string{"id"}},
+		&Table{SchemaName: "public", TableName: "unchanged", PrimaryKeys: []string{"id"}, Columns: []string{"id"}},
+		&Table{SchemaName: "public", TableName: "recent", Kind: KindView, PrimaryKeys: []string{"id"},
+			Columns: []string{"id"}},
+	)
+	live := description(
+		&Table{SchemaName: "public", TableName: "reordered", PrimaryKeys: []string{"b", "a"},
+			Identity: IdentityPrimaryKey, Columns: []string{"a", "b"}},
+		&Table{SchemaName: "public", TableName: "promoted", PrimaryKeys: []string{"id"},
+			Identity: IdentityPrimaryKey, Columns: []string{"id", "code"}},
+		&Table{SchemaName: "public", TableName: "dropped", Identity: IdentityRowLocation, Columns: []string{"id"},
+			Disabled: true},
+		&Table{SchemaName: "public", TableName: "unchanged", PrimaryKeys: []string{"id"},
+			Identity: IdentityPrimaryKey, Columns: []string{"id"}},
+		&Table{SchemaName: "public", TableName: "recent", Kind: KindView, Columns: []string{"id"}, Disabled: true},
+	)
+
+	merged, drift, err := Merge(saved, live, DriftAdd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"public.reordered": "primary key (b, a)",
+		"public.promoted":  "primary key (id)",
+		"public.dropped":   "the location of its rows",
+	}
+	if !reflect.DeepEqual(drift.ChangedIdentities, expected) {
+		t.Errorf("expected changed identities %v, got %v", expected, drift.ChangedIdentities)
+	}
+
+	reordered, _ := merged.Table("public", "reordered")
+	if !reflect.DeepEqual(reordered.PrimaryKeys, []string{"b", "a"}) {
+		t.Errorf("expected the key order of the database, got %v", reordered.PrimaryKeys)
+	}
+
+	promoted, _ := merged.Table("public", "promoted")
+	if promoted.Identity != IdentityPrimaryKey || promoted.IdentityIndex != "" ||
+		!reflect.DeepEqual(promoted.PrimaryKeys, []string{"id"}) {
+		t.Errorf("expected the primary key of the database, got %s %s %v", promoted.Identity,
+			promoted.IdentityIndex, promoted.PrimaryKeys)
+	}
+	if !reflect.DeepEqual(promoted.Columns, []string{"code", "id"}) {
+		t.Errorf("expected the new key column to be added, got %v", promoted.Columns)
+	}
+	if err := promoted.Validate(); err != nil {
+		t.Error(err)
+	}
+
+	dropped, _ := merged.Table("public", "dropped")
+	if dropped.Identity != IdentityRowLocation || len(dropped.PrimaryKeys) != 0 || !dropped.Disabled {
+		t.Errorf("expected the table to be disabled and identified by ctid, got %+v", dropped)
+	}
+
+	recent, _ := merged.Table("public", "recent")
+	if !reflect.DeepEqual(recent.PrimaryKeys, []string{"id"}) {
+		t.Errorf("expected the keys declared for the view to be kept, got %v", recent.PrimaryKeys)
+	}
+}
+
+func TestMergePolicies(t *testing.T) {
+	saved := description(&Table{SchemaName: "public", TableName: "films", PrimaryKeys: []string{"id"},
+		Columns: []string{"id"}, ColumnMetadata: metadata("id")})
+	live := description(&Table{SchemaName: "public", TableName: "films", PrimaryKeys: []string{"code"},
+		Identity: IdentityPrimaryKey, Columns: []string{"id", "code"}, ColumnMetadata: metadata("id", "code")})
+
+	merged, drift, err := Merge(saved, live, DriftIgnore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drift.Empty() {
+		t.Error("expected the drift to be reported")
+	}
+	films, _ := merged.Table("public", "films")
+	if !reflect.DeepEqual(films.Columns, []string{"id"}) || !reflect.DeepEqual(films.PrimaryKeys, []string{"id"}) {
+		t.Errorf("expected the table to be left as it is, got %+v", films)
+	}
+
+	if _, drift, err := Merge(saved, live, DriftFail); err == nil {
+		t.Errorf("expected the merge to fail, got drift %s", drift)
+	}
+
+	if _, _, err := Merge(saved, saved, DriftFail); err != nil {
+		t.Errorf("expected no drift between identical descriptions, got %v", err)
+	}
+
+	if _, _, err := Merge(saved, live, "overwrite"); err == nil {
+		t.Error("expected an unknown policy to fail")
+	}
+}
@@ -0,0 +1,22 @@
+package domain
+
+// Masking policies, telling how the values of a column are hidden before they are sent.
+const (
+	// MaskDrop leaves the column out of the objects.
+	MaskDrop = "drop"
+	// MaskRedact replaces every value of the column with RedactedValue.
+	MaskRedact = "redact"
+	// MaskTruncate keeps the first Length characters of the values.
+	MaskTruncate = "truncate"
+	// MaskHash replaces the values with their keyed HMAC-SHA256, so equal values still match downstream.
+	MaskHash = "hmac"
+)
+
+// RedactedValue is sent in place of the values of columns masked with MaskRedact.
+const RedactedValue = "[REDACTED]"
+
+// Mask is the masking policy of a column.
+type Mask struct {
+	Policy string `json:"policy"`
+	Length int    `json:"length,omitempty"`
+}
@@ -0,0 +1,152 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Rules select the schemas, tables and columns of the database that are added to the schema by --init. Each
+// pattern is a glob, such as "tmp_*", or a regular expression if it is wrapped in slashes, such as "/^tmp_\d+$/".
+type Rules struct {
+	Schemas PatternSet `json:"schemas"`
+	// Tables patterns match table names, or names qualified with their schema such as "public.tmp_*".
+	Tables PatternSet `json:"tables"`
+	// Columns patterns match column names, or names qualified with their schema and table.
+	Columns PatternSet `json:"columns"`
+	// Types patterns match the type of columns, either its underlying type such as "bytea" or the type as
+	// displayed by psql such as "character varying(255)".
+	Types PatternSet `json:"types"`
+}
+
+// PatternSet matches names that match any of the include patterns, or any name if there are none, and none of the
+// exclude patterns.
+type PatternSet struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+
+	include []*pattern
+	exclude []*pattern
+}
+
+type pattern struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+func NewRulesFromReader(r io.Reader) (*Rules, error) {
+	rules := &Rules{}
+	if err := json.NewDecoder(r).Decode(rules); err != nil {
+		return nil, err
+	}
+
+	for _, s := range []*PatternSet{&rules.Schemas, &rules.Tables, &rules.Columns, &rules.Types} {
+		var err error
+		if s.include, err = compilePatterns(s.Include); err != nil {
+			return nil, err
+		}
+		if s.exclude, err = compilePatterns(s.Exclude); err != nil {
+			return nil, err
+		}
+	}
+
+	return rules, nil
+}
+
+func compilePatterns(patterns []string) ([]*pattern, error) {
+	compiled := []*pattern{}
+	for _, p := range patterns {
+		if len(p) > 1 && strings.HasPrefix(p, "/") && strings.HasSuffix(p, "/") {
+			re, err := regexp.Compile(p[1 : len(p)-1])
+			if err != nil {
+				return nil, fmt.Errorf("pattern %q: %s", p, err)
+			}
+			compiled = append(compiled, &pattern{re: re})
+			continue
+		}
+
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("pattern %q: %s", p, err)
+		}
+		compiled = append(compiled, &pattern{glob: p})
+	}
+	return compiled, nil
+}
+
+func (p *pattern) match(name string) bool {
+	if p.re != nil {
+		return p.re.MatchString(name)
+	}
+	matched, _ := path.Match(p.glob, name)
+	return matched
+}
+
+// Match returns true if any of the names matches the set. Names are the forms of the same name, such as a table
+// name with and without its schema.
+func (s *PatternSet) Match(names ...string) bool {
+	matchAny := func(patterns []*pattern) bool {
+		for _, p := range patterns {
+			for _, name := range names {
+				if p.match(name) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	if len(s.include) > 0 && !matchAny(s.include) {
+		return false
+	}
+	return !matchAny(s.exclude)
+}
+
+// Select returns the tables and columns of the description matched by the rules. Primary key columns are always
+// kept, and query tables are not filtered. Columns that are left out stay in the column metadata of their table, so
+// that merging the database into the schema later doesn't take them for new columns.
+func (d *Description) Select(rules *Rules) *Description {
+	selected := NewDescription()
+
+	for t := range d.Iter() {
+		if t.Kind != KindQuery {
+			if !rules.Schemas.Match(t.SchemaName) {
+				continue
+			}
+			if !rules.Tables.Match(t.TableName, fmt.Sprintf("%s.%s", t.SchemaName, t.TableName)) {
+				continue
+			}
+			selectColumns(t, rules)
+		}
+		selected.AddTable(t)
+	}
+
+	return selected
+}
+
+func selectColumns(t *Table, rules *Rules) {
+	keys := map[string]bool{}
+	for _, pk := range t.PrimaryKeys {
+		keys[pk] = true
+	}
+
+	columns := []string{}
+	for _, c := range t.Columns {
+		if keys[c] || selectColumn(t, c, rules) {
+			columns = append(columns, c)
+		}
+	}
+	t.Columns = columns
+}
+
+func selectColumn(t *Table, column string, rules *Rules) bool {
+	if !rules.Columns.Match(column, fmt.Sprintf("%s.%s.%s", t.SchemaName, t.TableName, column)) {
+		return false
+	}
+	if m, ok := t.ColumnMetadata[column]; ok && !rules.Types.Match(m.UDTName, m.DataType) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,122 @@
+package domain
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPatternSetMatch(t *testing.T) {
+	rules, err := NewRulesFromReader(strings.NewReader(`{
+		"tables": {"include": ["public.*", "/^audit\\.log_\\d+$/"], "exclude": ["tmp_*"]}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		names    []string
+		expected bool
+	}{
+		{[]string{"films", "public.films"}, true},
+		{[]string{"tmp_films", "public.tmp_films"}, false},
+		{[]string{"log_1", "audit.log_1"}, true},
+		{[]string{"log_a", "audit.log_a"}, false},
+		{[]string{"films", "other.films"}, false},
+	}
+
+	for _, test := range tests {
+		if actual := rules.Tables.Match(test.names...); actual != test.expected {
+			t.Errorf("%v: expected %v, got %v", test.names, test.expected, actual)
+		}
+	}
+
+	if !rules.Schemas.Match("anything") {
+		t.Error("expected a set without patterns to match every name")
+	}
+}
+
+func TestNewRulesFromReaderInvalidPattern(t *testing.T) {
+	for _, rules := range []string{
+		`{"tables": {"exclude": ["[a"]}}`,
+		`{"columns": {"include": ["/(/"]}}`,
+		`{"schemas": []}`,
+	} {
+		if _, err := NewRulesFromReader(strings.NewReader(rules)); err == nil {
+			t.Errorf("%s: expected an error", rules)
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	rules, err := NewRulesFromReader(strings.NewReader(`{
+		"schemas": {"exclude": ["topology"]},
+		"tables": {"exclude": ["tmp_*"]},
+		"columns": {"exclude": ["*_legacy", "public.films.secret"]},
+		"types": {"exclude": ["bytea"]}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := metadata("id_legacy", "title", "secret", "poster", "code_legacy")
+	m["poster"].UDTName = "bytea"
+
+	d := description(
+		&Table{SchemaName: "public", TableName: "films", PrimaryKeys: []string{"id_legacy"},
+			Columns: []string{"id_legacy", "title", "secret", "poster", "code_legacy"}, ColumnMetadata: m},
+		&Table{SchemaName: "public", TableName: "tmp_films", PrimaryKeys: []string{"id"}, Columns: []string{"id"}},
+		&Table{SchemaName: "topology", TableName: "layer", PrimaryKeys: []string{"id"}, Columns: []string{"id"}},
+		&Table{SchemaName: "reporting", TableName: "tmp_totals", Kind: KindQuery, Query: "SELECT 1 AS code_legacy",
+			PrimaryKeys: []string{"code_legacy"}, Columns: []string{"code_legacy"}},
+	)
+
+	selected := d.Select(rules)
+
+	if _, ok := selected.Table("public", "tmp_films"); ok {
+		t.Error("expected the excluded table to be left out")
+	}
+	if _, ok := selected.Table("topology", "layer"); ok {
+		t.Error("expected the table of the excluded schema to be left out")
+	}
+	if totals, ok := selected.Table("reporting", "tmp_totals"); !ok || !reflect.DeepEqual(totals.Columns,
+		[]string{"code_legacy"}) {
+		t.Error("expected the query table to be kept as it is")
+	}
+
+	films, ok := selected.Table("public", "films")
+	if !ok {
+		t.Fatal("expected the table to be selected")
+	}
+	if !reflect.DeepEqual(films.Columns, []string{"id_legacy", "title"}) {
+		t.Errorf("expected the key and the title, got %v", films.Columns)
+	}
+	if len(films.ColumnMetadata) != 5 {
+		t.Errorf("expected the metadata of every column to be kept, got %v", films.ColumnMetadata)
+	}
+}
+
+// TestSelectMerge checks that the columns left out by the rules aren't added back by a merge.
+func TestSelectMerge(t *testing.T) {
+	rules, err := NewRulesFromReader(strings.NewReader(`{"columns": {"exclude": ["secret"]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	describe := func() *Description {
+		return description(&Table{SchemaName: "public", TableName: "films", PrimaryKeys: []string{"id"},
+			Identity: IdentityPrimaryKey, Columns: []string{"id", "secret"}, ColumnMetadata: metadata("id", "secret")})
+	}
+
+	saved := describe().Select(rules)
+	merged, drift, err := Merge(saved, describe(), DriftAdd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !drift.Empty() {
+		t.Errorf("expected no drift, got %s", drift)
+	}
+	if films, _ := merged.Table("public", "films"); !reflect.DeepEqual(films.Columns, []string{"id"}) {
+		t.Errorf("expected the excluded column to stay excluded, got %v", films.Columns)
+	}
+}
@@ -0,0 +1,165 @@
+package domain
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// State keeps the per-table sync progress that has to survive between runs.
+type State struct {
+	m      sync.Mutex
+	tables map[string]map[string]*TableState
+}
+
+func NewState() *State {
+	return &State{
+		tables: make(map[string]map[string]*TableState),
+	}
+}
+
+func NewStateFromReader(r io.Reader) (*State, error) {
+	s := NewState()
+	dec := json.NewDecoder(r)
+	// numbers are kept in their text form so large integer keys don't lose precision
+	dec.UseNumber()
+	if err := dec.Decode(&s.tables); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Restore copies the saved state of the table into t.State. The saved state is discarded if the table's marker
+// column has changed since the last run, and the checkpoint if it was recorded for other key columns than the
+// table's. States saved without their key columns only keep the checkpoint of single column keys, since the
+// columns of longer keys may have been recorded in another order.
+func (s *State) Restore(t *Table) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	t.State = TableState{MarkerColumn: t.MarkerColumn}
+
+	saved, ok := s.tables[t.SchemaName][t.TableName]
+	if !ok || saved.MarkerColumn != t.MarkerColumn {
+		return
+	}
+
+	keys := t.KeyColumns()
+	sameKey := sameColumns(saved.KeyColumns, keys)
+	if saved.KeyColumns == nil {
+		sameKey = len(keys) == 1
+	}
+
+	t.State.LastMarker = stateValue(saved.LastMarker)
+	if saved.Partition != "" {
+		t.State.NextMarker = stateValue(saved.NextMarker)
+		t.State.Partition = saved.Partition
+		if sameKey && len(saved.LastPkValues) == len(keys) {
+			t.State.LastPkValues = convertValues(saved.LastPkValues, stateValue)
+		}
+	} else if sameKey && len(saved.LastPkValues) == len(keys) {
+		t.State.NextMarker = stateValue(saved.NextMarker)
+		t.State.LastPkValues = convertValues(saved.LastPkValues, stateValue)
+	} else if sameKey && len(saved.KeyRanges) > 0 {
+		t.State.NextMarker = stateValue(saved.NextMarker)
+		for _, r := range saved.KeyRanges {
+			if r.LastPkValues != nil && len(r.LastPkValues) != len(keys) {
+				r.LastPkValues = nil
+			}
+			t.State.KeyRanges = append(t.State.KeyRanges, convertRange(r, stateValue))
+		}
+	}
+}
+
+// Update records the current state of the table.
+func (s *State) Update(t *Table) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if _, ok := s.tables[t.SchemaName]; !ok {
+		s.tables[t.SchemaName] = map[string]*TableState{}
+	}
+
+	state := t.State
+	state.LastPkValues = convertValues(t.State.LastPkValues, checkpointValue)
+	state.KeyRanges = nil
+	for _, r := range t.State.KeyRanges {
+		state.KeyRanges = append(state.KeyRanges, convertRange(r, checkpointValue))
+	}
+	state.KeyColumns = nil
+	if state.LastPkValues != nil || state.KeyRanges != nil {
+		state.KeyColumns = t.KeyColumns()
+	}
+	s.tables[t.SchemaName][t.TableName] = &state
+}
+
+func (s *State) Save(w io.Writer) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	b, err := json.MarshalIndent(s.tables, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func sameColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func convertValues(values []interface{}, convert func(interface{}) interface{}) []interface{} {
+	if values == nil {
+		return nil
+	}
+	res := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		res = append(res, convert(v))
+	}
+	return res
+}
+
+func convertRange(r *KeyRange, convert func(interface{}) interface{}) *KeyRange {
+	return &KeyRange{
+		Lower:        convert(r.Lower),
+		Upper:        convert(r.Upper),
+		LastPkValues: convertValues(r.LastPkValues, convert),
+		Done:         r.Done,
+	}
+}
+
+// checkpointValue converts a value read from the database into a form that survives the JSON round trip. Values
+// that JSON can't represent exactly are saved in their text form, which the database parses back when the value is
+// used as a query argument.
+func checkpointValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case []byte:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return v
+}
+
+// stateValue converts a value loaded from the state file into a query argument.
+func stateValue(v interface{}) interface{} {
+	if n, ok := v.(json.Number); ok {
+		return n.String()
+	}
+	return v
+}
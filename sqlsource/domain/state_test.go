@@ -0,0 +1,137 @@
+package domain
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// roundTrip saves the state of the table and restores it into a copy of the table with the given key.
+func roundTrip(t *testing.T, table *Table, primaryKeys []string) *Table {
+	s := NewState()
+	s.Update(table)
+
+	var b bytes.Buffer
+	if err := s.Save(&b); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := NewStateFromReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := &Table{SchemaName: table.SchemaName, TableName: table.TableName, PrimaryKeys: primaryKeys,
+		MarkerColumn: table.MarkerColumn}
+	loaded.Restore(restored)
+	return restored
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	table := &Table{SchemaName: "public", TableName: "events", PrimaryKeys: []string{"id", "at", "tag"},
+		MarkerColumn: "updated_at"}
+	table.State = TableState{
+		MarkerColumn: "updated_at",
+		LastMarker:   "2020-01-01 00:00:00",
+		NextMarker:   "2020-01-02 00:00:00",
+		LastPkValues: []interface{}{
+			int64(9007199254740993),
+			time.Date(2020, 1, 2, 3, 4, 5, 6000, time.UTC),
+			[]byte("a"),
+		},
+	}
+
+	restored := roundTrip(t, table, []string{"id", "at", "tag"})
+
+	expected := TableState{
+		MarkerColumn: "updated_at",
+		LastMarker:   "2020-01-01 00:00:00",
+		NextMarker:   "2020-01-02 00:00:00",
+		LastPkValues: []interface{}{"9007199254740993", "2020-01-02T03:04:05.000006Z", "a"},
+	}
+	if !reflect.DeepEqual(restored.State, expected) {
+		t.Errorf("expected %+v, got %+v", expected, restored.State)
+	}
+}
+
+func TestStateRoundTripKeyRanges(t *testing.T) {
+	table := &Table{SchemaName: "public", TableName: "events", PrimaryKeys: []string{"id"}}
+	table.State = TableState{KeyRanges: []*KeyRange{
+		{Upper: int64(100), Done: true},
+		{Lower: int64(100), Upper: int64(200), LastPkValues: []interface{}{int64(150)}},
+		{Lower: int64(200)},
+	}}
+
+	restored := roundTrip(t, table, []string{"id"})
+
+	expected := []*KeyRange{
+		{Upper: "100", Done: true},
+		{Lower: "100", Upper: "200", LastPkValues: []interface{}{"150"}},
+		{Lower: "200"},
+	}
+	if !reflect.DeepEqual(restored.State.KeyRanges, expected) {
+		t.Errorf("expected %v, got %v", expected, restored.State.KeyRanges)
+	}
+}
+
+func TestStateRestoreDiscardsOtherKeys(t *testing.T) {
+	table := &Table{SchemaName: "public", TableName: "events", PrimaryKeys: []string{"a", "b"}}
+	table.State = TableState{LastPkValues: []interface{}{"x", "y"}}
+
+	if restored := roundTrip(t, table, []string{"b", "a"}); restored.State.LastPkValues != nil {
+		t.Errorf("expected the checkpoint of a reordered key to be discarded, got %v", restored.State.LastPkValues)
+	}
+	if restored := roundTrip(t, table, []string{"a", "c"}); restored.State.LastPkValues != nil {
+		t.Errorf("expected the checkpoint of another key to be discarded, got %v", restored.State.LastPkValues)
+	}
+
+	table.State = TableState{KeyRanges: []*KeyRange{{Upper: "m"}, {Lower: "m"}}}
+	if restored := roundTrip(t, table, []string{"b", "a"}); restored.State.KeyRanges != nil {
+		t.Errorf("expected the ranges of another key to be discarded, got %v", restored.State.KeyRanges)
+	}
+
+	table.State = TableState{Partition: `"public"."events_1"`, LastPkValues: []interface{}{"x", "y"}}
+	restored := roundTrip(t, table, []string{"b", "a"})
+	if restored.State.Partition != `"public"."events_1"` || restored.State.LastPkValues != nil {
+		t.Errorf("expected the partition to be resumed from its start, got %+v", restored.State)
+	}
+}
+
+func TestStateRestoreWithoutKeyColumns(t *testing.T) {
+	s, err := NewStateFromReader(strings.NewReader(`{
+		"public": {
+			"single": {"last_pk_values": [42]},
+			"compound": {"last_pk_values": ["x", "y"]}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	single := &Table{SchemaName: "public", TableName: "single", PrimaryKeys: []string{"id"}}
+	s.Restore(single)
+	if !reflect.DeepEqual(single.State.LastPkValues, []interface{}{"42"}) {
+		t.Errorf("expected the checkpoint of a single column key to be kept, got %v", single.State.LastPkValues)
+	}
+
+	compound := &Table{SchemaName: "public", TableName: "compound", PrimaryKeys: []string{"a", "b"}}
+	s.Restore(compound)
+	if compound.State.LastPkValues != nil {
+		t.Errorf("expected the checkpoint of a compound key to be discarded, got %v", compound.State.LastPkValues)
+	}
+}
+
+func TestStateRestoreDiscardsOtherMarker(t *testing.T) {
+	table := &Table{SchemaName: "public", TableName: "events", PrimaryKeys: []string{"id"}, MarkerColumn: "a"}
+	table.State = TableState{MarkerColumn: "a", LastMarker: "1", LastPkValues: []interface{}{"x"}}
+
+	s := NewState()
+	s.Update(table)
+
+	table.MarkerColumn = "b"
+	s.Restore(table)
+	if !reflect.DeepEqual(table.State, TableState{MarkerColumn: "b"}) {
+		t.Errorf("expected the state to be discarded, got %+v", table.State)
+	}
+}
@@ -0,0 +1,237 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// Identity strategies, telling how the objects of a table are identified.
+const (
+	// IdentityPrimaryKey identifies objects by the table's primary key.
+	IdentityPrimaryKey = "primary_key"
+	// IdentityUniqueIndex identifies objects by the columns of a unique index on non-null columns.
+	IdentityUniqueIndex = "unique_index"
+	// IdentityRowLocation pages through the table by the physical location of its rows, and identifies objects by a
+	// hash of their content. It is only suitable for append-only tables.
+	IdentityRowLocation = "ctid"
+)
+
+// ID strategies, telling how the key values of a row are combined into its object ID.
+const (
+	// IDStrategyJoin joins the values with underscores. Compound keys whose values contain underscores can
+	// produce the same ID, it is kept as the default so the IDs of most tables don't change. Key values are
+	// converted by the driver first, which changed the IDs of keys such as timestamps and bytea.
+	IDStrategyJoin = "join"
+	// IDStrategyEscaped joins the values with underscores after escaping the underscores and backslashes they
+	// contain.
+	IDStrategyEscaped = "escaped"
+	// IDStrategyJSON encodes the values as a JSON array, which preserves their types.
+	IDStrategyJSON = "json"
+	// IDStrategyHash uses the SHA-256 hash of the JSON array of values, for fixed length IDs.
+	IDStrategyHash = "hash"
+)
+
+// Kinds of relations other than plain tables.
+const (
+	KindView             = "view"
+	KindMaterializedView = "materialized_view"
+	// KindPartitioned tables are scanned one partition at a time.
+	KindPartitioned = "partitioned"
+	// KindQuery tables are defined by a custom query in the schema instead of a database relation.
+	KindQuery = "query"
+)
+
+// RowLocationColumn is the column holding the physical location of rows, used to page through tables identified
+// by IdentityRowLocation.
+const RowLocationColumn = "ctid"
+
+type TableState struct {
+	ScannedRows  uint64      `json:"scanned_rows,omitempty"`
+	MarkerColumn string      `json:"marker_column,omitempty"`
+	LastMarker   interface{} `json:"last_marker,omitempty"`
+
+	// NextMarker is the upper bound of the marker window for the current scan. It becomes LastMarker once the
+	// table has been scanned successfully.
+	NextMarker interface{} `json:"next_marker,omitempty"`
+
+	// LastPkValues is the primary key of the last checkpointed row of an unfinished scan.
+	LastPkValues []interface{} `json:"last_pk_values,omitempty"`
+
+	// Partition is the partition of an unfinished scan of a partitioned table, LastPkValues is the checkpoint
+	// within that partition.
+	Partition string `json:"partition,omitempty"`
+
+	// KeyRanges are the ranges of an unfinished scan that is split into key ranges.
+	KeyRanges []*KeyRange `json:"key_ranges,omitempty"`
+
+	// KeyColumns are the columns the table was paged through by when LastPkValues and KeyRanges were recorded.
+	KeyColumns []string `json:"key_columns,omitempty"`
+
+	// SubstitutedValues counts the values of the run that could not be sent as is and were replaced, such as NaN
+	// floats. It is only reported in the run summary.
+	SubstitutedValues uint64 `json:"-"`
+}
+
+// KeyRange is a range of values of the leading primary key column that is scanned independently of the rest of the
+// table. A nil bound leaves that end of the range open.
+type KeyRange struct {
+	Lower        interface{}   `json:"lower,omitempty"`
+	Upper        interface{}   `json:"upper,omitempty"`
+	LastPkValues []interface{} `json:"last_pk_values,omitempty"`
+	Done         bool          `json:"done,omitempty"`
+}
+
+type Table struct {
+	SchemaName  string   `json:"-"`
+	TableName   string   `json:"-"`
+	PrimaryKeys []string `json:"primary_keys"`
+	Columns     []string `json:"columns"`
+
+	// ColumnMetadata describes the type of the table's columns, by column name.
+	ColumnMetadata map[string]*ColumnMetadata `json:"column_metadata,omitempty"`
+
+	// JSONAsString lists the JSON columns that are sent as strings instead of nested objects.
+	JSONAsString []string `json:"json_as_string,omitempty"`
+
+	// LatLon lists the geometry and geography columns whose points are sent as lat and lon properties instead of
+	// GeoJSON objects.
+	LatLon []string `json:"lat_lon,omitempty"`
+
+	// Masks are the masking policies of the columns holding sensitive values, by column name.
+	Masks map[string]*Mask `json:"masks,omitempty"`
+
+	// Collection is the name of the collection the table's objects are sent to, it overrides the naming template.
+	Collection string `json:"collection,omitempty"`
+
+	MarkerColumn string `json:"marker_column,omitempty"`
+
+	// MarkerLag is subtracted from the maximum of the marker column captured when a scan starts, so that rows
+	// committed after it was captured with an earlier marker are still picked up by the next run. Drivers take it
+	// as a duration in their own syntax.
+	MarkerLag string `json:"marker_lag,omitempty"`
+
+	// Identity is the strategy used to identify the table's objects, IdentityPrimaryKey if it's not set.
+	Identity      string `json:"identity,omitempty"`
+	IdentityIndex string `json:"identity_index,omitempty"`
+
+	// IDStrategy is how the key values are combined into object IDs, IDStrategyJoin if it's not set.
+	IDStrategy string `json:"id_strategy,omitempty"`
+
+	// IDColumns are the columns object IDs are built from, instead of the primary key. They have to be unique and
+	// not null, the table is still paged through by its primary key.
+	IDColumns []string `json:"id_columns,omitempty"`
+
+	// Kind is the kind of relation when it's not a plain table, such as "view" or "materialized_view".
+	Kind string `json:"kind,omitempty"`
+
+	// Query is the SELECT statement defining tables of KindQuery.
+	Query string `json:"query,omitempty"`
+
+	// Filter is a predicate rows have to match to be synced, drivers supporting it add it to their scan queries.
+	Filter string `json:"filter,omitempty"`
+
+	// Disabled tables are listed in the schema but not synced.
+	Disabled bool `json:"disabled,omitempty"`
+
+	// ChunkSize is the number of rows read by each Scan, drivers use their own default if it's not set.
+	ChunkSize int `json:"chunk_size,omitempty"`
+
+	// FetchSize makes drivers that support it stream each chunk through a server-side cursor, fetching FetchSize
+	// rows at a time.
+	FetchSize int `json:"fetch_size,omitempty"`
+
+	// Ranges is the number of key ranges the table is split into, ranges are scanned concurrently.
+	Ranges int `json:"ranges,omitempty"`
+
+	// Range limits scans to a single key range.
+	Range *KeyRange `json:"-"`
+
+	// Partition limits scans of a partitioned table to a single partition.
+	Partition string `json:"-"`
+
+	// ExpandedKeyset pages through the table with a condition spelled out column by column instead of a row
+	// comparison, for drivers that support both.
+	ExpandedKeyset bool `json:"expanded_keyset,omitempty"`
+
+	// DetectDeletes publishes tombstone objects for rows that were removed since the previous scan.
+	DetectDeletes bool `json:"detect_deletes,omitempty"`
+
+	State TableState `json:"-"`
+
+	// keyPositions are the positions of PrimaryKeys in the key while the table is being described.
+	keyPositions []int
+}
+
+func (t *Table) IncrScanned() {
+	atomic.AddUint64(&t.State.ScannedRows, 1)
+}
+
+func (t *Table) IncrSubstituted() {
+	atomic.AddUint64(&t.State.SubstitutedValues, 1)
+}
+
+// KeyColumns returns the columns the table is paged through by.
+func (t *Table) KeyColumns() []string {
+	if t.Identity == IdentityRowLocation {
+		return []string{RowLocationColumn}
+	}
+	return t.PrimaryKeys
+}
+
+// ObjectIDColumns returns the columns object IDs are built from.
+func (t *Table) ObjectIDColumns() []string {
+	if len(t.IDColumns) > 0 {
+		return t.IDColumns
+	}
+	return t.PrimaryKeys
+}
+
+// Validate checks that the table declares how its rows are identified.
+func (t *Table) Validate() error {
+	if t.Kind == KindQuery && t.Query == "" {
+		return fmt.Errorf("%s.%s: no query declared", t.SchemaName, t.TableName)
+	}
+	switch t.IDStrategy {
+	case "", IDStrategyJoin, IDStrategyEscaped, IDStrategyJSON, IDStrategyHash:
+	default:
+		return fmt.Errorf("%s.%s: unknown id_strategy %q", t.SchemaName, t.TableName, t.IDStrategy)
+	}
+	if t.Identity == IdentityRowLocation {
+		return nil
+	}
+	if len(t.PrimaryKeys) == 0 {
+		return fmt.Errorf("%s.%s: no primary_keys declared", t.SchemaName, t.TableName)
+	}
+
+	columns := map[string]bool{}
+	for _, c := range t.Columns {
+		columns[c] = true
+	}
+	for _, pk := range t.PrimaryKeys {
+		if !columns[pk] {
+			return fmt.Errorf("%s.%s: primary key %q is not in columns", t.SchemaName, t.TableName, pk)
+		}
+	}
+	for _, c := range t.IDColumns {
+		if !columns[c] {
+			return fmt.Errorf("%s.%s: id column %q is not in columns", t.SchemaName, t.TableName, c)
+		}
+	}
+
+	return nil
+}
+
+// IsIncremental returns true if the table is synced incrementally using a marker column.
+func (t *Table) IsIncremental() bool {
+	return t.MarkerColumn != ""
+}
+
+func (t *Table) ColumnToSQL() string {
+	c := []string{}
+	for _, column := range t.Columns {
+		c = append(c, fmt.Sprintf("%q", column))
+	}
+
+	return strings.Join(c, ", ")
+}
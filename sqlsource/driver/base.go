@@ -0,0 +1,729 @@
+package driver
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/segment-sources/source-postgres/sqlsource/domain"
+	"github.com/segmentio/go-snakecase"
+	"github.com/segmentio/objects-go"
+)
+
+type Driver interface {
+	Init(*domain.Config) error
+	Describe() (*domain.Description, error)
+	Scan(t *domain.Table, afterPKValues []interface{}) (SqlRows, error)
+	Transform(t *domain.Table, row map[string]interface{}) map[string]interface{}
+}
+
+type SqlRows interface {
+	Next() bool
+	MapScan(map[string]interface{}) error
+	Err() error
+	Close() error
+}
+
+// MarkerDriver is implemented by drivers that support incremental scans driven by a marker column.
+type MarkerDriver interface {
+	// MaxMarker returns the current maximum value of the table's marker column, or nil if the table is empty.
+	MaxMarker(t *domain.Table) (interface{}, error)
+}
+
+// Change is a single row change read from the database's change stream.
+type Change struct {
+	Schema string
+	Table  string
+	// Row holds the new values of the row, or the key of the removed row if Deleted is set.
+	Row map[string]interface{}
+	// OldKey holds the previous primary key values if an update changed the key of the row.
+	OldKey  map[string]interface{}
+	Deleted bool
+}
+
+// StreamDriver is implemented by drivers that can stream row changes instead of rescanning tables.
+type StreamDriver interface {
+	// Changes returns up to roughly limit pending changes for the tables of the description, together with the
+	// position to acknowledge once they have been published. Changes are not consumed until Ack is called.
+	Changes(d *domain.Description, limit int) ([]*Change, string, error)
+	// Ack marks every change up to and including position as processed.
+	Ack(d *domain.Description, position string) error
+}
+
+// RangeDriver is implemented by drivers that can split a table into key ranges that are scanned concurrently.
+type RangeDriver interface {
+	// SplitRanges returns up to n-1 increasing values of the table's leading primary key column that split it into
+	// ranges of roughly equal size.
+	SplitRanges(t *domain.Table, n int) ([]interface{}, error)
+}
+
+// PartitionDriver is implemented by drivers that can scan partitioned tables one partition at a time.
+type PartitionDriver interface {
+	// Partitions returns the partitions of the table holding rows, identified in a way Scan understands when it is
+	// set as the table's Partition.
+	Partitions(t *domain.Table) ([]string, error)
+}
+
+// QueryDriver is implemented by drivers that support tables defined by a custom query.
+type QueryDriver interface {
+	// QueryColumns returns the names of the columns returned by the table's query.
+	QueryColumns(t *domain.Table) ([]string, error)
+}
+
+// FilterDriver is implemented by drivers that support tables with a filter.
+type FilterDriver interface {
+	// ValidateFilter checks that the table's filter is a valid predicate for the table.
+	ValidateFilter(t *domain.Table) error
+}
+
+// IDColumnsDriver is implemented by drivers that can check the id_columns of tables against the database.
+type IDColumnsDriver interface {
+	// ValidateIDColumns checks that the table's ID columns identify its rows.
+	ValidateIDColumns(t *domain.Table) error
+
+	// StreamsIDColumns tells whether the changes streamed for updates and deletes of the table carry the values of
+	// its ID columns.
+	StreamsIDColumns(t *domain.Table) (bool, error)
+}
+
+// CheckpointDriver is implemented by drivers whose key values don't survive the state file as they are read, such as
+// times whose zone the database would ignore.
+type CheckpointDriver interface {
+	// KeyText returns the values of the table's key columns in the text form the database parses them back from.
+	KeyText(t *domain.Table, values []interface{}) []interface{}
+}
+
+// DefaultCollectionTemplate names collections after the schema and name of their table.
+const DefaultCollectionTemplate = "{schema}_{table}"
+
+type Base struct {
+	Driver Driver
+
+	// Checkpoint, if set along with Flush, is called after each chunk with the table's state pointing at the row a
+	// scan can safely be resumed from.
+	Checkpoint func(t *domain.Table) error
+
+	// Flush returns once every object published so far has been delivered, or an error if any of them was lost.
+	// Scans are only checkpointed right after a successful flush.
+	Flush func() error
+
+	// KeysDir is the directory where the keys seen by scans of tables with delete detection are kept.
+	KeysDir string
+
+	// CollectionTemplate names the collection of tables that don't set their own, "{schema}" and "{table}" are
+	// replaced with the table's schema and name. DefaultCollectionTemplate is used if it's not set.
+	CollectionTemplate string
+
+	// MaskKey is the key of the HMAC used by columns masked with domain.MaskHash.
+	MaskKey []byte
+}
+
+func (b *Base) ScanTable(t *domain.Table, publisher domain.ObjectPublisher) (err error) {
+	resumed := t.State.LastPkValues != nil || t.State.KeyRanges != nil || t.State.Partition != ""
+	if resumed {
+		log.WithFields(log.Fields{"table": t.TableName, "schema": t.SchemaName, "after": t.State.LastPkValues}).Info("Resuming scan")
+	}
+
+	// a resumed scan keeps the marker window it was started with, otherwise rows before the checkpoint changed
+	// since then would never be synced
+	if t.IsIncremental() && (!resumed || t.State.NextMarker == nil) {
+		md, ok := b.Driver.(MarkerDriver)
+		if !ok {
+			return fmt.Errorf("%s.%s: driver does not support marker columns", t.SchemaName, t.TableName)
+		}
+		if t.State.NextMarker, err = md.MaxMarker(t); err != nil {
+			return
+		}
+		log.WithFields(log.Fields{"table": t.TableName, "schema": t.SchemaName, "from": t.State.LastMarker,
+			"to": t.State.NextMarker}).Info("Incremental scan")
+	}
+
+	var keys *keySetWriter
+	if t.DetectDeletes {
+		switch {
+		case t.IsIncremental():
+			log.WithFields(log.Fields{"table": t.TableName, "schema": t.SchemaName}).Warn("Deletes can't be detected on incremental tables")
+		case resumed:
+			log.WithFields(log.Fields{"table": t.TableName, "schema": t.SchemaName}).Warn("Skipping delete detection for resumed scan")
+		default:
+			if keys, err = newKeySetWriter(keySetDir(b.KeysDir, t) + ".scan"); err != nil {
+				return
+			}
+			defer func() {
+				if keys != nil {
+					keys.Close()
+					os.RemoveAll(keySetDir(b.KeysDir, t) + ".scan")
+				}
+			}()
+		}
+	}
+
+	if t.Kind == domain.KindPartitioned {
+		err = b.scanPartitions(t, publisher, keys)
+	} else if t.Ranges > 1 || t.State.KeyRanges != nil {
+		err = b.scanRanges(t, publisher, keys)
+	} else {
+		err = b.scanChunks(t, t.State.LastPkValues, publisher, keys, func(lastPkValues []interface{}) error {
+			t.State.LastPkValues = lastPkValues
+			return b.Checkpoint(t)
+		})
+	}
+	if err != nil {
+		return
+	}
+
+	if keys != nil {
+		err = keys.Close()
+		keys = nil
+		if err != nil {
+			return
+		}
+		if err = b.publishDeletes(t, publisher); err != nil {
+			return
+		}
+	}
+
+	t.State.LastPkValues = nil
+	t.State.KeyRanges = nil
+	t.State.Partition = ""
+	if t.IsIncremental() {
+		if t.State.NextMarker != nil {
+			t.State.LastMarker = t.State.NextMarker
+		}
+		t.State.NextMarker = nil
+	}
+
+	return nil
+}
+
+// ValidateQuery checks that the query of a KindQuery table returns every column of the table. Tables that don't
+// list their columns get every column of the query.
+func (b *Base) ValidateQuery(t *domain.Table) error {
+	qd, ok := b.Driver.(QueryDriver)
+	if !ok {
+		return fmt.Errorf("%s.%s: driver does not support query tables", t.SchemaName, t.TableName)
+	}
+
+	columns, err := qd.QueryColumns(t)
+	if err != nil {
+		return fmt.Errorf("%s.%s: %v", t.SchemaName, t.TableName, err)
+	}
+
+	if len(t.Columns) == 0 {
+		t.Columns = columns
+	}
+
+	returned := map[string]bool{}
+	for _, c := range columns {
+		returned[c] = true
+	}
+	for _, c := range t.Columns {
+		if !returned[c] {
+			return fmt.Errorf("%s.%s: column %q is not returned by the query", t.SchemaName, t.TableName, c)
+		}
+	}
+
+	return t.Validate()
+}
+
+// ValidateFilter checks the filter of a table, if it has one.
+func (b *Base) ValidateFilter(t *domain.Table) error {
+	if t.Filter == "" {
+		return nil
+	}
+
+	fd, ok := b.Driver.(FilterDriver)
+	if !ok {
+		return fmt.Errorf("%s.%s: driver does not support filters", t.SchemaName, t.TableName)
+	}
+	return fd.ValidateFilter(t)
+}
+
+// ValidateIDColumns checks the id_columns of a table, if it has any and the driver can check them.
+func (b *Base) ValidateIDColumns(t *domain.Table) error {
+	if len(t.IDColumns) == 0 {
+		return nil
+	}
+
+	id, ok := b.Driver.(IDColumnsDriver)
+	if !ok {
+		return nil
+	}
+	return id.ValidateIDColumns(t)
+}
+
+// StreamsIDColumns tells whether the changes streamed for the table carry the values of its id_columns. Tables
+// without id_columns, and tables of drivers that can't tell, are assumed to.
+func (b *Base) StreamsIDColumns(t *domain.Table) (bool, error) {
+	if len(t.IDColumns) == 0 {
+		return true, nil
+	}
+
+	id, ok := b.Driver.(IDColumnsDriver)
+	if !ok {
+		return true, nil
+	}
+	return id.StreamsIDColumns(t)
+}
+
+// scanChunks scans the table chunk by chunk, starting after lastPkValues. checkpoint is called with the primary
+// key a scan can safely be resumed from after each chunk if Base has a Checkpoint and a Flush function.
+func (b *Base) scanChunks(t *domain.Table, lastPkValues []interface{}, publisher domain.ObjectPublisher, keys *keySetWriter, checkpoint func([]interface{}) error) (err error) {
+	for {
+		lastPkValues, err = b.scanTableChunk(t, lastPkValues, publisher, keys)
+
+		if err != nil {
+			return
+		}
+		if lastPkValues == nil {
+			return nil
+		}
+
+		// the objects of the chunk are delivered before it is checkpointed, so a resumed scan never skips rows that
+		// were not sent
+		if b.Checkpoint != nil && b.Flush != nil {
+			if err = b.Flush(); err != nil {
+				return fmt.Errorf("%s.%s: %v", t.SchemaName, t.TableName, err)
+			}
+			if err = checkpoint(b.keyText(t, lastPkValues)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// keyText returns the key values in the form they are checkpointed in.
+func (b *Base) keyText(t *domain.Table, values []interface{}) []interface{} {
+	if cd, ok := b.Driver.(CheckpointDriver); ok {
+		return cd.KeyText(t, values)
+	}
+	return values
+}
+
+// scanPartitions scans the partitions of the table one after the other, in order, so a resumed scan can skip the
+// partitions that were already scanned.
+func (b *Base) scanPartitions(t *domain.Table, publisher domain.ObjectPublisher, keys *keySetWriter) error {
+	pd, ok := b.Driver.(PartitionDriver)
+	if !ok {
+		return fmt.Errorf("%s.%s: driver does not support partitioned tables", t.SchemaName, t.TableName)
+	}
+	if t.Ranges > 1 {
+		log.WithFields(log.Fields{"table": t.TableName, "schema": t.SchemaName}).Warn("Partitioned tables are not split into key ranges")
+	}
+
+	partitions, err := pd.Partitions(t)
+	if err != nil {
+		return err
+	}
+	sort.Strings(partitions)
+
+	for _, partition := range partitions {
+		if partition < t.State.Partition {
+			continue
+		}
+
+		var lastPkValues []interface{}
+		if partition == t.State.Partition {
+			lastPkValues = t.State.LastPkValues
+		} else {
+			t.State.Partition = partition
+			t.State.LastPkValues = nil
+		}
+		log.WithFields(log.Fields{"table": t.TableName, "schema": t.SchemaName, "partition": partition}).Info("Scanning partition")
+
+		pt := *t
+		pt.Partition = partition
+		pt.State.ScannedRows = 0
+		pt.State.SubstitutedValues = 0
+
+		err := b.scanChunks(&pt, lastPkValues, publisher, keys, func(lastPkValues []interface{}) error {
+			t.State.LastPkValues = lastPkValues
+			return b.Checkpoint(t)
+		})
+		t.State.ScannedRows += pt.State.ScannedRows
+		t.State.SubstitutedValues += pt.State.SubstitutedValues
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// scanRanges splits the table into key ranges, unless it is resuming a scan that was already split, and scans the
+// ranges concurrently. Each range is checkpointed on its own.
+func (b *Base) scanRanges(t *domain.Table, publisher domain.ObjectPublisher, keys *keySetWriter) error {
+	if t.State.KeyRanges == nil {
+		rd, ok := b.Driver.(RangeDriver)
+		if !ok {
+			return fmt.Errorf("%s.%s: driver does not support key ranges", t.SchemaName, t.TableName)
+		}
+
+		bounds, err := rd.SplitRanges(t, t.Ranges)
+		if err != nil {
+			return err
+		}
+
+		var lower interface{}
+		for _, upper := range bounds {
+			t.State.KeyRanges = append(t.State.KeyRanges, &domain.KeyRange{Lower: lower, Upper: upper})
+			lower = upper
+		}
+		t.State.KeyRanges = append(t.State.KeyRanges, &domain.KeyRange{Lower: lower})
+		log.WithFields(log.Fields{"table": t.TableName, "schema": t.SchemaName, "bounds": bounds}).Info("Split into key ranges")
+	}
+
+	// m guards the key ranges and the counters of t while ranges are scanned
+	var m sync.Mutex
+	var wg sync.WaitGroup
+	var firstErr error
+
+	for _, r := range t.State.KeyRanges {
+		if r.Done {
+			continue
+		}
+
+		wg.Add(1)
+		go func(r *domain.KeyRange) {
+			defer wg.Done()
+
+			rt := *t
+			rt.Range = r
+			rt.State.ScannedRows = 0
+			rt.State.SubstitutedValues = 0
+
+			err := b.scanChunks(&rt, r.LastPkValues, publisher, keys, func(lastPkValues []interface{}) error {
+				m.Lock()
+				defer m.Unlock()
+				r.LastPkValues = lastPkValues
+				return b.Checkpoint(t)
+			})
+
+			m.Lock()
+			defer m.Unlock()
+			t.State.ScannedRows += rt.State.ScannedRows
+			t.State.SubstitutedValues += rt.State.SubstitutedValues
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			r.Done = true
+			r.LastPkValues = nil
+		}(r)
+	}
+
+	wg.Wait()
+	return firstErr
+}
+
+// scanTableChunk performs Scan operation on the driver and returns values of primary keys from the last row or an empty
+// array if no rows were returned from the driver
+func (b *Base) scanTableChunk(t *domain.Table, afterPKValues []interface{}, publisher domain.ObjectPublisher, keys *keySetWriter) ([]interface{}, error) {
+	rows, err := b.Driver.Scan(t, afterPKValues)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	keyColumns := t.KeyColumns()
+	lastPkValues := make([]interface{}, 0, len(keyColumns))
+	for rows.Next() {
+		row := map[string]interface{}{}
+		if err := rows.MapScan(row); err != nil {
+			return nil, err
+		}
+		t.IncrScanned()
+
+		// the next chunk starts after the last key, which compares with NULL to nothing and would end the scan early
+		lastPkValues = lastPkValues[:0]
+		for _, p := range keyColumns {
+			if row[p] == nil {
+				return nil, fmt.Errorf("%s.%s: key column %s is null", t.SchemaName, t.TableName, p)
+			}
+			lastPkValues = append(lastPkValues, row[p])
+		}
+		if t.Identity == domain.IdentityRowLocation {
+			delete(row, domain.RowLocationColumn)
+		}
+
+		// rows are only logged once masked, so debug logs don't hold the values masks hide
+		row = b.mask(t, b.Driver.Transform(t, row))
+		log.WithFields(log.Fields{"row": row, "table": t.TableName, "schema": t.SchemaName}).Debugf("Received Row")
+		id := objectID(t, row)
+
+		if keys != nil {
+			if err := keys.Add(id, objectKey(t, row)); err != nil {
+				return nil, err
+			}
+		}
+
+		publisher(&objects.Object{
+			ID:         id,
+			Collection: b.Collection(t),
+			Properties: row,
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(lastPkValues) < len(keyColumns) {
+		return nil, nil
+	}
+
+	return lastPkValues, nil
+}
+
+// publishDeletes publishes tombstone objects for the keys of the previous scan that were not seen by the current one.
+func (b *Base) publishDeletes(t *domain.Table, publisher domain.ObjectPublisher) error {
+	dir := keySetDir(b.KeysDir, t)
+
+	var deleted uint64
+	err := diffKeySets(dir, dir+".scan", func(r *keyRecord) {
+		deleted++
+		publisher(b.tombstone(t, r.ID, r.Key))
+	})
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{"table": t.TableName, "schema": t.SchemaName, "count": deleted}).Info("Deletes detected")
+	return nil
+}
+
+// CommitKeys makes the keys seen by the table's last finished scan the baseline the next scan is diffed against.
+// It must only be called once the tombstones of the scan have been sent.
+func (b *Base) CommitKeys(t *domain.Table) error {
+	dir := keySetDir(b.KeysDir, t)
+	if _, err := os.Stat(dir + ".scan"); os.IsNotExist(err) {
+		return nil
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return os.Rename(dir+".scan", dir)
+}
+
+// PublishChanges publishes changes read from a StreamDriver. Deleted rows are published as tombstone objects.
+func (b *Base) PublishChanges(d *domain.Description, changes []*Change, publisher domain.ObjectPublisher) {
+	for _, c := range changes {
+		t, ok := d.Table(c.Schema, c.Table)
+		if !ok || t.Disabled {
+			continue
+		}
+		// changes are logged without their values, which aren't masked yet
+		log.WithFields(log.Fields{"deleted": c.Deleted, "table": t.TableName, "schema": t.SchemaName}).Debugf("Received Change")
+		t.IncrScanned()
+
+		// rows identified by their content can't be matched with their previous version
+		if t.Identity == domain.IdentityRowLocation && (c.Deleted || c.OldKey != nil) {
+			if c.Deleted {
+				continue
+			}
+			c.OldKey = nil
+		}
+
+		// keys are converted like rows, so their IDs match the IDs of the objects they refer to
+		if c.Deleted {
+			key := b.mask(t, b.Driver.Transform(t, objectKey(t, c.Row)))
+			publisher(b.tombstone(t, objectID(t, key), key))
+			continue
+		}
+
+		row := map[string]interface{}{}
+		for _, column := range t.Columns {
+			if v, ok := c.Row[column]; ok {
+				row[column] = v
+			}
+		}
+		row = b.mask(t, b.Driver.Transform(t, row))
+		id := objectID(t, row)
+
+		if c.OldKey != nil {
+			oldKey := b.mask(t, b.Driver.Transform(t, objectKey(t, c.OldKey)))
+			if oldID := objectID(t, oldKey); oldID != id {
+				publisher(b.tombstone(t, oldID, oldKey))
+			}
+		}
+
+		publisher(&objects.Object{
+			ID:         id,
+			Collection: b.Collection(t),
+			Properties: row,
+		})
+	}
+}
+
+// objectKey returns a copy of the values of the row's ID columns.
+func objectKey(t *domain.Table, row map[string]interface{}) map[string]interface{} {
+	key := map[string]interface{}{}
+	for _, p := range t.ObjectIDColumns() {
+		key[p] = row[p]
+	}
+	return key
+}
+
+// tombstone returns an object marking the row identified by key as deleted.
+func (b *Base) tombstone(t *domain.Table, id string, key map[string]interface{}) *objects.Object {
+	properties := map[string]interface{}{
+		"_deleted":    true,
+		"_deleted_at": time.Now().UTC().Format(time.RFC3339),
+	}
+	for _, p := range t.ObjectIDColumns() {
+		properties[p] = key[p]
+	}
+
+	return &objects.Object{
+		ID:         id,
+		Collection: b.Collection(t),
+		Properties: properties,
+	}
+}
+
+// objectID combines the ID column values of the row according to the table's ID strategy.
+func objectID(t *domain.Table, row map[string]interface{}) string {
+	if t.Identity == domain.IdentityRowLocation {
+		return contentHash(row)
+	}
+
+	values := []interface{}{}
+	for _, c := range t.ObjectIDColumns() {
+		values = append(values, row[c])
+	}
+
+	switch t.IDStrategy {
+	case domain.IDStrategyEscaped:
+		escaped := []string{}
+		for _, v := range values {
+			escaped = append(escaped, idEscaper.Replace(idText(v)))
+		}
+		return strings.Join(escaped, "_")
+	case domain.IDStrategyJSON:
+		return idJSON(values)
+	case domain.IDStrategyHash:
+		sum := sha256.Sum256([]byte(idJSON(values)))
+		return hex.EncodeToString(sum[:])
+	}
+
+	pks := []string{}
+	for _, v := range values {
+		pks = append(pks, fmt.Sprintf("%v", v))
+	}
+	return strings.Join(pks, "_")
+}
+
+// idEscaper escapes the separator of IDStrategyEscaped IDs.
+var idEscaper = strings.NewReplacer(`\`, `\\`, "_", `\_`)
+
+// idText returns the text of a key value, binary values are hex encoded and times formatted as RFC 3339.
+func idText(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return hex.EncodeToString(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func idJSON(values []interface{}) string {
+	b, err := json.Marshal(values)
+	if err != nil {
+		return fmt.Sprintf("%v", values)
+	}
+	return string(b)
+}
+
+// contentHash returns a hash of the row's values, used to identify rows of tables without a key.
+func contentHash(row map[string]interface{}) string {
+	b, err := json.Marshal(row)
+	if err != nil {
+		b = []byte(fmt.Sprintf("%v", row))
+	}
+	sum := sha1.Sum(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Collection returns the name of the collection the table's objects are sent to, the table's own collection if it
+// sets one, or the collection naming template expanded with its schema and table names and snake cased.
+func (b *Base) Collection(t *domain.Table) string {
+	if t.Collection != "" {
+		return t.Collection
+	}
+
+	template := b.CollectionTemplate
+	if template == "" {
+		template = DefaultCollectionTemplate
+	}
+	r := strings.NewReplacer("{schema}", t.SchemaName, "{table}", t.TableName)
+	return snakecase.Snakecase(r.Replace(template))
+}
+
+// ValidateCollections checks that no two tables of the description are sent to the same collection. Disabled
+// tables are only checked if includeDisabled is set.
+func (b *Base) ValidateCollections(d *domain.Description, includeDisabled bool) error {
+	collisions, _ := b.collisions(d, includeDisabled)
+	if len(collisions) > 0 {
+		return fmt.Errorf("collection collisions, set the collection of one of the tables: %s",
+			strings.Join(collisions, "; "))
+	}
+	return nil
+}
+
+// DisableCollisions disables the tables sent to the collection of another table of the description, keeping the
+// first of them in schema and table name order, and returns the names of the tables it disabled. Disabled tables
+// are only considered if includeDisabled is set, as for ValidateCollections.
+func (b *Base) DisableCollisions(d *domain.Description, includeDisabled bool) []string {
+	_, colliding := b.collisions(d, includeDisabled)
+	names := []string{}
+	for _, t := range colliding {
+		t.Disabled = true
+		names = append(names, fmt.Sprintf("%s.%s", t.SchemaName, t.TableName))
+	}
+	return names
+}
+
+// collisions describes every table sent to the collection of a table that comes before it in schema and table name
+// order, and returns these tables.
+func (b *Base) collisions(d *domain.Description, includeDisabled bool) ([]string, []*domain.Table) {
+	tables := map[string]*domain.Table{}
+	names := []string{}
+	for t := range d.Iter() {
+		if includeDisabled || !t.Disabled {
+			name := fmt.Sprintf("%s.%s", t.SchemaName, t.TableName)
+			tables[name] = t
+			names = append(names, name)
+		}
+	}
+	// go through the tables in order so the same collision is always reported the same way
+	sort.Strings(names)
+
+	owners := map[string]string{}
+	collisions := []string{}
+	colliding := []*domain.Table{}
+	for _, name := range names {
+		c := b.Collection(tables[name])
+		if owner, ok := owners[c]; ok {
+			collisions = append(collisions, fmt.Sprintf("%s and %s are both sent to %q", owner, name, c))
+			colliding = append(colliding, tables[name])
+			continue
+		}
+		owners[c] = name
+	}
+	return collisions, colliding
+}
@@ -0,0 +1,274 @@
+package driver
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/segment-sources/source-postgres/sqlsource/domain"
+	"github.com/segmentio/objects-go"
+)
+
+func TestObjectID(t *testing.T) {
+	row := map[string]interface{}{"a": "a_b", "b": "c", "n": int64(1), "external_id": `x\y`}
+
+	tests := []struct {
+		name     string
+		table    *domain.Table
+		row      map[string]interface{}
+		expected string
+	}{
+		{
+			name:     "join",
+			table:    &domain.Table{PrimaryKeys: []string{"a", "b"}},
+			row:      row,
+			expected: "a_b_c",
+		},
+		{
+			name:     "escaped",
+			table:    &domain.Table{PrimaryKeys: []string{"a", "b"}, IDStrategy: domain.IDStrategyEscaped},
+			row:      row,
+			expected: `a\_b_c`,
+		},
+		{
+			name:     "escaped backslash",
+			table:    &domain.Table{PrimaryKeys: []string{"external_id", "n"}, IDStrategy: domain.IDStrategyEscaped},
+			row:      row,
+			expected: `x\\y_1`,
+		},
+		{
+			name:     "json",
+			table:    &domain.Table{PrimaryKeys: []string{"a", "b", "n"}, IDStrategy: domain.IDStrategyJSON},
+			row:      row,
+			expected: `["a_b","c",1]`,
+		},
+		{
+			name:     "hash",
+			table:    &domain.Table{PrimaryKeys: []string{"a", "b"}, IDStrategy: domain.IDStrategyHash},
+			row:      row,
+			expected: "6769bfc9a95eecbce683474c92565f5d08e65924b046a4e4996b683315789f57",
+		},
+		{
+			name:     "id columns",
+			table:    &domain.Table{PrimaryKeys: []string{"a", "b"}, IDColumns: []string{"external_id"}},
+			row:      row,
+			expected: `x\y`,
+		},
+		{
+			name:     "row location",
+			table:    &domain.Table{Identity: domain.IdentityRowLocation},
+			row:      map[string]interface{}{"message": "hello"},
+			expected: "1c2dbefb7e62b37c2155f2648ddd03b97812e40a",
+		},
+	}
+
+	for _, test := range tests {
+		if actual := objectID(test.table, test.row); actual != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, actual)
+		}
+	}
+}
+
+// TestObjectIDEscapedCollisions checks that keys joined into the same ID by the default strategy get different
+// escaped IDs.
+func TestObjectIDEscapedCollisions(t *testing.T) {
+	table := &domain.Table{PrimaryKeys: []string{"a", "b"}, IDStrategy: domain.IDStrategyEscaped}
+
+	keys := [][]string{
+		{"a_b", "c"},
+		{"a", "b_c"},
+		{`a\`, "b_c"},
+		{`a\_b`, "c"},
+		{`a\\`, "_b"},
+	}
+	seen := map[string][]string{}
+	for _, key := range keys {
+		id := objectID(table, map[string]interface{}{"a": key[0], "b": key[1]})
+		if other, ok := seen[id]; ok {
+			t.Errorf("%v and %v both get the ID %s", other, key, id)
+		}
+		seen[id] = key
+	}
+}
+
+func TestIDText(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected string
+	}{
+		{"abc", "abc"},
+		{int64(42), "42"},
+		{[]byte{0xde, 0xad}, "dead"},
+		{time.Date(2020, 1, 2, 3, 4, 5, 6000, time.UTC), "2020-01-02T03:04:05.000006Z"},
+	}
+
+	for _, test := range tests {
+		if actual := idText(test.value); actual != test.expected {
+			t.Errorf("%#v: expected %s, got %s", test.value, test.expected, actual)
+		}
+	}
+}
+
+// testDriver transforms values by prefixing strings with "t:", so tests can tell transformed values apart.
+type testDriver struct {
+	Driver
+}
+
+func (d *testDriver) Transform(t *domain.Table, row map[string]interface{}) map[string]interface{} {
+	for column, v := range row {
+		if s, ok := v.(string); ok {
+			row[column] = "t:" + s
+		}
+	}
+	return row
+}
+
+func TestPublishChanges(t *testing.T) {
+	films := &domain.Table{SchemaName: "public", TableName: "films", PrimaryKeys: []string{"code"},
+		Columns: []string{"code", "title"}}
+	d := domain.NewDescription()
+	d.AddTable(films)
+	d.AddTable(&domain.Table{SchemaName: "public", TableName: "drafts", PrimaryKeys: []string{"id"},
+		Columns: []string{"id"}, Disabled: true})
+	d.AddTable(&domain.Table{SchemaName: "public", TableName: "logs", Identity: domain.IdentityRowLocation,
+		Columns: []string{"message"}})
+
+	changes := []*Change{
+		{Schema: "public", Table: "films", Row: map[string]interface{}{"code": "a", "title": "A", "len": 1}},
+		{Schema: "public", Table: "films", Row: map[string]interface{}{"code": "b", "title": "B"},
+			OldKey: map[string]interface{}{"code": "a"}},
+		{Schema: "public", Table: "films", Row: map[string]interface{}{"code": "b", "title": "C"},
+			OldKey: map[string]interface{}{"code": "b"}},
+		{Schema: "public", Table: "films", Row: map[string]interface{}{"code": "b"}, Deleted: true},
+		{Schema: "public", Table: "drafts", Row: map[string]interface{}{"id": "1"}},
+		{Schema: "public", Table: "unknown", Row: map[string]interface{}{"id": "1"}},
+		{Schema: "public", Table: "logs", Row: map[string]interface{}{"message": "m"}, Deleted: true},
+	}
+
+	published := []*objects.Object{}
+	b := &Base{Driver: &testDriver{}}
+	b.PublishChanges(d, changes, func(o *objects.Object) {
+		published = append(published, o)
+	})
+
+	expected := []struct {
+		id         string
+		deleted    bool
+		properties map[string]interface{}
+	}{
+		{"t:a", false, map[string]interface{}{"code": "t:a", "title": "t:A"}},
+		{"t:a", true, map[string]interface{}{"code": "t:a"}},
+		{"t:b", false, map[string]interface{}{"code": "t:b", "title": "t:B"}},
+		{"t:b", false, map[string]interface{}{"code": "t:b", "title": "t:C"}},
+		{"t:b", true, map[string]interface{}{"code": "t:b"}},
+	}
+	if len(published) != len(expected) {
+		t.Fatalf("expected %d objects, got %d: %v", len(expected), len(published), published)
+	}
+	for i, e := range expected {
+		o := published[i]
+		if o.ID != e.id || o.Collection != "public_films" {
+			t.Errorf("object %d: expected %s in public_films, got %s in %s", i, e.id, o.ID, o.Collection)
+		}
+		if _, deleted := o.Properties["_deleted"]; deleted != e.deleted {
+			t.Errorf("object %d: expected deleted %v, got %v", i, e.deleted, o.Properties)
+		}
+		delete(o.Properties, "_deleted")
+		delete(o.Properties, "_deleted_at")
+		if !reflect.DeepEqual(o.Properties, e.properties) {
+			t.Errorf("object %d: expected %v, got %v", i, e.properties, o.Properties)
+		}
+	}
+
+	if films.State.ScannedRows != 4 {
+		t.Errorf("expected 4 changes counted, got %d", films.State.ScannedRows)
+	}
+}
+
+// rowsDriver scans the given rows in a single chunk.
+type rowsDriver struct {
+	testDriver
+	rows []map[string]interface{}
+}
+
+func (d *rowsDriver) Scan(t *domain.Table, afterPKValues []interface{}) (SqlRows, error) {
+	if afterPKValues != nil {
+		return &sliceRows{}, nil
+	}
+	return &sliceRows{rows: d.rows}, nil
+}
+
+func TestScanTableNullKey(t *testing.T) {
+	table := &domain.Table{SchemaName: "public", TableName: "totals", Kind: domain.KindMaterializedView,
+		PrimaryKeys: []string{"title"}, Columns: []string{"title"}}
+	b := &Base{Driver: &rowsDriver{rows: []map[string]interface{}{{"title": "a"}, {"title": nil}, {"title": "b"}}}}
+
+	published := 0
+	err := b.ScanTable(table, func(o *objects.Object) {
+		published++
+	})
+	if err == nil {
+		t.Fatal("expected a null key to fail the scan")
+	}
+	if published != 1 {
+		t.Errorf("expected the rows before the null key to be published, got %d", published)
+	}
+}
+
+func TestCollisions(t *testing.T) {
+	description := func() *domain.Description {
+		d := domain.NewDescription()
+		d.AddTable(&domain.Table{SchemaName: "a", TableName: "b_c"})
+		d.AddTable(&domain.Table{SchemaName: "a_b", TableName: "c"})
+		d.AddTable(&domain.Table{SchemaName: "a_b", TableName: "d", Disabled: true})
+		d.AddTable(&domain.Table{SchemaName: "a", TableName: "b_d"})
+		d.AddTable(&domain.Table{SchemaName: "x", TableName: "y", Collection: "a_b_c"})
+		d.AddTable(&domain.Table{SchemaName: "public", TableName: "films"})
+		return d
+	}
+
+	tests := []struct {
+		includeDisabled bool
+		collisions      []string
+		disabled        []string
+	}{
+		{
+			includeDisabled: false,
+			collisions: []string{
+				`a.b_c and a_b.c are both sent to "a_b_c"`,
+				`a.b_c and x.y are both sent to "a_b_c"`,
+			},
+			disabled: []string{"a_b.c", "x.y"},
+		},
+		{
+			includeDisabled: true,
+			collisions: []string{
+				`a.b_c and a_b.c are both sent to "a_b_c"`,
+				`a.b_d and a_b.d are both sent to "a_b_d"`,
+				`a.b_c and x.y are both sent to "a_b_c"`,
+			},
+			disabled: []string{"a_b.c", "a_b.d", "x.y"},
+		},
+	}
+
+	for _, test := range tests {
+		b := &Base{}
+		d := description()
+
+		collisions, _ := b.collisions(d, test.includeDisabled)
+		if !reflect.DeepEqual(collisions, test.collisions) {
+			t.Errorf("%v: expected collisions %v, got %v", test.includeDisabled, test.collisions, collisions)
+		}
+		if err := b.ValidateCollections(d, test.includeDisabled); err == nil {
+			t.Errorf("%v: expected the collisions to be reported", test.includeDisabled)
+		}
+
+		disabled := b.DisableCollisions(d, test.includeDisabled)
+		if !reflect.DeepEqual(disabled, test.disabled) {
+			t.Errorf("%v: expected %v disabled, got %v", test.includeDisabled, test.disabled, disabled)
+		}
+		if err := b.ValidateCollections(d, false); err != nil {
+			t.Errorf("%v: unexpected error %v", test.includeDisabled, err)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package driver
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/segment-sources/source-postgres/sqlsource/domain"
+)
+
+// keySetBuckets is the number of files a key set is split into. Sets are diffed one bucket at a time, so only a
+// fraction of the previous run's keys has to be held in memory.
+const keySetBuckets = 64
+
+type keyRecord struct {
+	ID  string                 `json:"id"`
+	Key map[string]interface{} `json:"key"`
+}
+
+// keySetWriter records the keys of the objects published during a scan in gzip compressed, hash partitioned files.
+type keySetWriter struct {
+	m       sync.Mutex
+	files   []*os.File
+	writers []*gzip.Writer
+	encs    []*json.Encoder
+}
+
+// keySetDir returns the directory holding the keys of the table's last finished scan, the keys of the scan in
+// progress are written next to it.
+func keySetDir(keysDir string, t *domain.Table) string {
+	return filepath.Join(keysDir, fmt.Sprintf("%s.%s", t.SchemaName, t.TableName))
+}
+
+func newKeySetWriter(dir string) (*keySetWriter, error) {
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	w := &keySetWriter{}
+	for i := 0; i < keySetBuckets; i++ {
+		f, err := os.Create(bucketPath(dir, i))
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		gz := gzip.NewWriter(f)
+		w.files = append(w.files, f)
+		w.writers = append(w.writers, gz)
+		w.encs = append(w.encs, json.NewEncoder(gz))
+	}
+
+	return w, nil
+}
+
+func (w *keySetWriter) Add(id string, key map[string]interface{}) error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	return w.encs[bucket(id)].Encode(&keyRecord{ID: id, Key: key})
+}
+
+func (w *keySetWriter) Close() error {
+	var err error
+	for i := range w.files {
+		if i < len(w.writers) {
+			if wErr := w.writers[i].Close(); err == nil {
+				err = wErr
+			}
+		}
+		if fErr := w.files[i].Close(); err == nil {
+			err = fErr
+		}
+	}
+	return err
+}
+
+// diffKeySets calls fn for every key of the set in prevDir that is missing from the set in currDir. Nothing is
+// reported if there is no previous set.
+func diffKeySets(prevDir, currDir string, fn func(r *keyRecord)) error {
+	if _, err := os.Stat(prevDir); os.IsNotExist(err) {
+		return nil
+	}
+
+	for i := 0; i < keySetBuckets; i++ {
+		prev := map[string]*keyRecord{}
+		err := readBucket(bucketPath(prevDir, i), func(r *keyRecord) {
+			prev[r.ID] = r
+		})
+		if err != nil {
+			return err
+		}
+
+		err = readBucket(bucketPath(currDir, i), func(r *keyRecord) {
+			delete(prev, r.ID)
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, r := range prev {
+			fn(r)
+		}
+	}
+
+	return nil
+}
+
+func readBucket(path string, fn func(r *keyRecord)) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	dec := json.NewDecoder(gz)
+	dec.UseNumber()
+	for dec.More() {
+		r := &keyRecord{}
+		if err := dec.Decode(r); err != nil {
+			return err
+		}
+		fn(r)
+	}
+
+	return nil
+}
+
+func bucket(id string) int {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return int(h.Sum32() % keySetBuckets)
+}
+
+func bucketPath(dir string, i int) string {
+	return filepath.Join(dir, fmt.Sprintf("%02d.json.gz", i))
+}
@@ -0,0 +1,175 @@
+package driver
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/segment-sources/source-postgres/sqlsource/domain"
+	"github.com/segmentio/objects-go"
+)
+
+// tempKeysDir creates a directory for key sets, removed by the returned function.
+func tempKeysDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// writeKeySet writes a key set holding the given IDs, each keyed by its own value.
+func writeKeySet(t *testing.T, dir string, ids ...string) {
+	w, err := newKeySetWriter(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if err := w.Add(id, map[string]interface{}{"id": id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiffKeySets(t *testing.T) {
+	keysDir, cleanup := tempKeysDir(t)
+	defer cleanup()
+
+	tests := []struct {
+		name     string
+		prev     []string
+		curr     []string
+		expected []string
+	}{
+		{"no previous set", nil, []string{"a", "b"}, []string{}},
+		{"deleted keys", []string{"a", "b", "c", "d"}, []string{"b", "e"}, []string{"a", "c", "d"}},
+		{"unchanged", []string{"a", "b"}, []string{"b", "a"}, []string{}},
+		{"everything deleted", []string{"a", "b"}, []string{}, []string{"a", "b"}},
+	}
+
+	for _, test := range tests {
+		prevDir := filepath.Join(keysDir, "prev")
+		currDir := filepath.Join(keysDir, "curr")
+		os.RemoveAll(prevDir)
+		if test.prev != nil {
+			writeKeySet(t, prevDir, test.prev...)
+		}
+		writeKeySet(t, currDir, test.curr...)
+
+		deleted := []string{}
+		err := diffKeySets(prevDir, currDir, func(r *keyRecord) {
+			if r.Key["id"] != r.ID {
+				t.Errorf("%s: expected the key of %s to be kept, got %v", test.name, r.ID, r.Key)
+			}
+			deleted = append(deleted, r.ID)
+		})
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		sort.Strings(deleted)
+		if !reflect.DeepEqual(deleted, test.expected) {
+			t.Errorf("%s: expected %v deleted, got %v", test.name, test.expected, deleted)
+		}
+	}
+}
+
+// sliceRows returns rows from a slice, and then err.
+type sliceRows struct {
+	rows []map[string]interface{}
+	err  error
+}
+
+func (r *sliceRows) Next() bool {
+	return len(r.rows) > 0
+}
+
+func (r *sliceRows) MapScan(row map[string]interface{}) error {
+	for column, v := range r.rows[0] {
+		row[column] = v
+	}
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func (r *sliceRows) Err() error {
+	return r.err
+}
+
+func (r *sliceRows) Close() error {
+	return nil
+}
+
+// scanDriver scans a table holding the rows with the given codes, in a single chunk, failing after them if err is set.
+type scanDriver struct {
+	testDriver
+	codes []string
+	err   error
+}
+
+func (d *scanDriver) Scan(t *domain.Table, afterPKValues []interface{}) (SqlRows, error) {
+	if afterPKValues != nil {
+		return &sliceRows{}, nil
+	}
+	rows := &sliceRows{err: d.err}
+	for _, code := range d.codes {
+		rows.rows = append(rows.rows, map[string]interface{}{"code": code})
+	}
+	return rows, nil
+}
+
+func TestScanTableDetectDeletes(t *testing.T) {
+	keysDir, cleanup := tempKeysDir(t)
+	defer cleanup()
+
+	table := &domain.Table{SchemaName: "public", TableName: "films", PrimaryKeys: []string{"code"},
+		Columns: []string{"code"}, DetectDeletes: true}
+	d := &scanDriver{}
+	b := &Base{Driver: d, KeysDir: keysDir}
+
+	scans := []struct {
+		name     string
+		codes    []string
+		err      error
+		expected []string
+	}{
+		{"first scan", []string{"a", "b", "c"}, nil, []string{}},
+		{"deleted row", []string{"a", "c"}, nil, []string{"t:b"}},
+		{"unchanged", []string{"a", "c"}, nil, []string{}},
+		{"failed scan", []string{"a"}, errors.New("connection reset"), []string{}},
+		{"scan after the failure", []string{"a"}, nil, []string{"t:c"}},
+	}
+
+	for _, scan := range scans {
+		d.codes, d.err = scan.codes, scan.err
+
+		deleted := []string{}
+		err := b.ScanTable(table, func(o *objects.Object) {
+			if o.Properties["_deleted"] == true {
+				deleted = append(deleted, o.ID)
+			}
+		})
+		if err != scan.err {
+			t.Errorf("%s: expected the error %v, got %v", scan.name, scan.err, err)
+		}
+		if err == nil {
+			if err := b.CommitKeys(table); err != nil {
+				t.Errorf("%s: unexpected error %v", scan.name, err)
+			}
+		} else if _, err := os.Stat(keySetDir(keysDir, table) + ".scan"); !os.IsNotExist(err) {
+			t.Errorf("%s: expected the keys of the failed scan to be removed", scan.name)
+		}
+
+		sort.Strings(deleted)
+		if !reflect.DeepEqual(deleted, scan.expected) {
+			t.Errorf("%s: expected %v deleted, got %v", scan.name, scan.expected, deleted)
+		}
+	}
+}
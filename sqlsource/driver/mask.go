@@ -0,0 +1,91 @@
+package driver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/segment-sources/source-postgres/sqlsource/domain"
+)
+
+// ValidateMasks checks the masking policies of the table. The columns IDs are built from can only be hashed, since
+// the other policies would make distinct rows share an ID.
+func (b *Base) ValidateMasks(t *domain.Table) error {
+	pks := map[string]bool{}
+	for _, pk := range t.ObjectIDColumns() {
+		pks[pk] = true
+	}
+
+	for column, m := range t.Masks {
+		switch m.Policy {
+		case domain.MaskDrop, domain.MaskRedact:
+		case domain.MaskTruncate:
+			if m.Length <= 0 {
+				return fmt.Errorf("%s.%s: mask of %q needs a positive length", t.SchemaName, t.TableName, column)
+			}
+		case domain.MaskHash:
+			if len(b.MaskKey) == 0 {
+				return fmt.Errorf("%s.%s: mask of %q needs a key, see --mask-key-file", t.SchemaName, t.TableName, column)
+			}
+			continue
+		default:
+			return fmt.Errorf("%s.%s: unknown mask policy %q for %q", t.SchemaName, t.TableName, m.Policy, column)
+		}
+
+		if pks[column] {
+			return fmt.Errorf("%s.%s: id column %q can only be masked with %q", t.SchemaName, t.TableName, column,
+				domain.MaskHash)
+		}
+	}
+
+	return nil
+}
+
+// mask applies the masking policies of the table to the values of the row, it must run before the row's ID is
+// computed so hashed keys don't leak into IDs.
+func (b *Base) mask(t *domain.Table, row map[string]interface{}) map[string]interface{} {
+	for column, m := range t.Masks {
+		v, ok := row[column]
+		if !ok {
+			continue
+		}
+		if m.Policy == domain.MaskDrop {
+			delete(row, column)
+			continue
+		}
+		if v == nil {
+			continue
+		}
+
+		switch m.Policy {
+		case domain.MaskRedact:
+			row[column] = domain.RedactedValue
+		case domain.MaskTruncate:
+			if r := []rune(maskText(v)); len(r) > m.Length {
+				row[column] = string(r[:m.Length])
+			} else {
+				row[column] = string(r)
+			}
+		case domain.MaskHash:
+			h := hmac.New(sha256.New, b.MaskKey)
+			h.Write([]byte(maskText(v)))
+			row[column] = hex.EncodeToString(h.Sum(nil))
+		}
+	}
+	return row
+}
+
+// maskText returns the text that is truncated or hashed for a value, strings are used as is and other values are
+// JSON encoded.
+func maskText(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(b)
+}
@@ -0,0 +1,159 @@
+package driver
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/segment-sources/source-postgres/sqlsource/domain"
+)
+
+func TestMask(t *testing.T) {
+	b := &Base{MaskKey: []byte("key")}
+
+	tests := []struct {
+		name     string
+		mask     *domain.Mask
+		value    interface{}
+		expected interface{}
+	}{
+		{"redact", &domain.Mask{Policy: domain.MaskRedact}, "alice@example.com", domain.RedactedValue},
+		{"redact a number", &domain.Mask{Policy: domain.MaskRedact}, int64(42), domain.RedactedValue},
+		{"redact null", &domain.Mask{Policy: domain.MaskRedact}, nil, nil},
+		{"truncate", &domain.Mask{Policy: domain.MaskTruncate, Length: 5}, "alice@example.com", "alice"},
+		{"truncate a short value", &domain.Mask{Policy: domain.MaskTruncate, Length: 5}, "bob", "bob"},
+		{"truncate multi-byte characters", &domain.Mask{Policy: domain.MaskTruncate, Length: 3}, "日本語のテキスト",
+			"日本語"},
+		{"truncate a number", &domain.Mask{Policy: domain.MaskTruncate, Length: 2}, json.Number("12345"), "12"},
+		{"truncate null", &domain.Mask{Policy: domain.MaskTruncate, Length: 2}, nil, nil},
+		{"hash", &domain.Mask{Policy: domain.MaskHash}, "alice@example.com",
+			"7f5869472f7937382ee449fa11667e1d9eb381ed70999a7177ff6faf6ca456dd"},
+		{"hash a number", &domain.Mask{Policy: domain.MaskHash}, int64(42),
+			"f2991b7ce981d0b5adc5e6a0f31acaeb407bfc21354bbcc31a0c43eaffa83d65"},
+		{"hash an array", &domain.Mask{Policy: domain.MaskHash}, []interface{}{1, 2},
+			"71adbbe6aa2ea5405eee2b0cc4773d7707ec67d96d3c8ed33fd56aa96507ac7e"},
+		{"hash null", &domain.Mask{Policy: domain.MaskHash}, nil, nil},
+	}
+
+	for _, test := range tests {
+		table := &domain.Table{PrimaryKeys: []string{"id"}, Masks: map[string]*domain.Mask{"value": test.mask}}
+		row := b.mask(table, map[string]interface{}{"id": int64(1), "value": test.value})
+
+		expected := map[string]interface{}{"id": int64(1), "value": test.expected}
+		if !reflect.DeepEqual(row, expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, expected, row)
+		}
+	}
+}
+
+func TestMaskDrop(t *testing.T) {
+	b := &Base{}
+	table := &domain.Table{PrimaryKeys: []string{"id"}, Masks: map[string]*domain.Mask{
+		"email": {Policy: domain.MaskDrop},
+		"phone": {Policy: domain.MaskDrop},
+		"ssn":   {Policy: domain.MaskRedact},
+	}}
+
+	row := b.mask(table, map[string]interface{}{"id": int64(1), "email": "alice@example.com", "phone": nil})
+
+	expected := map[string]interface{}{"id": int64(1)}
+	if !reflect.DeepEqual(row, expected) {
+		t.Errorf("expected %v, got %v", expected, row)
+	}
+}
+
+// TestMaskHashDeterministic checks that equal values get the same hash, so they can still be joined on, and that the
+// hash depends on the key.
+func TestMaskHashDeterministic(t *testing.T) {
+	table := &domain.Table{Masks: map[string]*domain.Mask{"email": {Policy: domain.MaskHash}}}
+	hash := func(key, v string) interface{} {
+		b := &Base{MaskKey: []byte(key)}
+		return b.mask(table, map[string]interface{}{"email": v})["email"]
+	}
+
+	if a, b := hash("key", "alice@example.com"), hash("key", "alice@example.com"); a != b {
+		t.Errorf("expected equal values to get the same hash, got %v and %v", a, b)
+	}
+	if a, b := hash("key", "alice@example.com"), hash("key", "bob@example.com"); a == b {
+		t.Errorf("expected different values to get different hashes, got %v", a)
+	}
+	if a, b := hash("key", "alice@example.com"), hash("other", "alice@example.com"); a == b {
+		t.Errorf("expected different keys to give different hashes, got %v", a)
+	}
+}
+
+func TestValidateMasks(t *testing.T) {
+	tests := []struct {
+		name      string
+		table     *domain.Table
+		key       string
+		expectErr bool
+	}{
+		{
+			name: "valid masks",
+			table: &domain.Table{PrimaryKeys: []string{"id"}, Masks: map[string]*domain.Mask{
+				"email": {Policy: domain.MaskDrop},
+				"name":  {Policy: domain.MaskRedact},
+				"zip":   {Policy: domain.MaskTruncate, Length: 3},
+				"id":    {Policy: domain.MaskHash},
+			}},
+			key: "key",
+		},
+		{
+			name: "unknown policy",
+			table: &domain.Table{PrimaryKeys: []string{"id"}, Masks: map[string]*domain.Mask{
+				"email": {Policy: "shuffle"},
+			}},
+			expectErr: true,
+		},
+		{
+			name: "truncate without a length",
+			table: &domain.Table{PrimaryKeys: []string{"id"}, Masks: map[string]*domain.Mask{
+				"zip": {Policy: domain.MaskTruncate},
+			}},
+			expectErr: true,
+		},
+		{
+			name: "hash without a key",
+			table: &domain.Table{PrimaryKeys: []string{"id"}, Masks: map[string]*domain.Mask{
+				"email": {Policy: domain.MaskHash},
+			}},
+			expectErr: true,
+		},
+		{
+			name: "redacted primary key",
+			table: &domain.Table{PrimaryKeys: []string{"id"}, Masks: map[string]*domain.Mask{
+				"id": {Policy: domain.MaskRedact},
+			}},
+			expectErr: true,
+		},
+		{
+			name: "truncated primary key",
+			table: &domain.Table{PrimaryKeys: []string{"id"}, Masks: map[string]*domain.Mask{
+				"id": {Policy: domain.MaskTruncate, Length: 3},
+			}},
+			expectErr: true,
+		},
+		{
+			name: "dropped id column",
+			table: &domain.Table{PrimaryKeys: []string{"id"}, IDColumns: []string{"email"},
+				Masks: map[string]*domain.Mask{"email": {Policy: domain.MaskDrop}}},
+			expectErr: true,
+		},
+		{
+			name: "redacted primary key of a table with id columns",
+			table: &domain.Table{PrimaryKeys: []string{"id"}, IDColumns: []string{"email"},
+				Masks: map[string]*domain.Mask{"id": {Policy: domain.MaskRedact}}},
+		},
+	}
+
+	for _, test := range tests {
+		b := &Base{MaskKey: []byte(test.key)}
+		err := b.ValidateMasks(test.table)
+		if test.expectErr && err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		} else if !test.expectErr && err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		}
+	}
+}
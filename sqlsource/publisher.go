@@ -0,0 +1,76 @@
+package sqlsource
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/segmentio/objects-go"
+)
+
+var errDroppedObjects = errors.New("objects could not be sent to the Objects API")
+
+// publisher sends objects to the Objects API and tells whether every one of them was delivered. objects-go retries
+// failed uploads for a while and then drops the batch, as it does for objects it can't encode, and reports both to
+// the client's OnError. A failure is sticky: once an object is lost, Flush and Close keep failing, so nothing
+// published afterwards is acknowledged either.
+type publisher struct {
+	writeKey string
+
+	// m is held for writing while the client is swapped, objects are published under the read lock
+	m      sync.RWMutex
+	client *objects.Client
+	failed int32
+}
+
+func newPublisher(writeKey string) *publisher {
+	p := &publisher{writeKey: writeKey}
+	p.client = p.newClient()
+	return p
+}
+
+func (p *publisher) newClient() *objects.Client {
+	client := objects.New(p.writeKey)
+	client.OnError = func(error) {
+		atomic.StoreInt32(&p.failed, 1)
+	}
+	return client
+}
+
+// Publish queues the object, it is a domain.ObjectPublisher.
+func (p *publisher) Publish(o *objects.Object) {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	if err := p.client.Set(o); err != nil {
+		atomic.StoreInt32(&p.failed, 1)
+		logrus.WithFields(logrus.Fields{"id": o.ID, "collection": o.Collection, "properties": o.Properties}).Warn(err)
+	}
+}
+
+// Flush waits until every object published so far has been uploaded, and returns an error if any object was lost.
+func (p *publisher) Flush() error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.client.Close()
+	p.client = p.newClient()
+	return p.err()
+}
+
+// Close flushes the objects published so far, no object can be published afterwards.
+func (p *publisher) Close() error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.client.Close()
+	return p.err()
+}
+
+func (p *publisher) err() error {
+	if atomic.LoadInt32(&p.failed) != 0 {
+		return errDroppedObjects
+	}
+	return nil
+}
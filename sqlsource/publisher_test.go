@@ -0,0 +1,34 @@
+package sqlsource
+
+import (
+	"math"
+	"testing"
+
+	"github.com/segmentio/objects-go"
+)
+
+func TestPublisherDroppedObject(t *testing.T) {
+	p := newPublisher("key")
+	if err := p.Flush(); err != nil {
+		t.Fatalf("expected nothing to fail, got %v", err)
+	}
+
+	// NaN can't be encoded, so objects-go drops the object without sending anything
+	p.Publish(&objects.Object{ID: "1", Collection: "films", Properties: map[string]interface{}{"len": math.NaN()}})
+	if err := p.Flush(); err != errDroppedObjects {
+		t.Fatalf("expected the dropped object to be reported, got %v", err)
+	}
+
+	// failures are sticky
+	if err := p.Close(); err != errDroppedObjects {
+		t.Errorf("expected the failure to be reported again, got %v", err)
+	}
+}
+
+func TestPublisherInvalidObject(t *testing.T) {
+	p := newPublisher("key")
+	p.Publish(&objects.Object{ID: "1", Collection: "films"})
+	if err := p.Close(); err != errDroppedObjects {
+		t.Errorf("expected the invalid object to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,534 @@
+package sqlsource
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/asaskevich/govalidator"
+	"github.com/segment-sources/source-postgres/sqlsource/domain"
+	"github.com/segment-sources/source-postgres/sqlsource/driver"
+	"github.com/tj/docopt"
+	"github.com/tj/go-sync/semaphore"
+)
+
+const (
+	Version = "0.0.1-beta"
+)
+
+var usage = `
+Usage:
+  dbsource
+    [--debug]
+    [--init]
+    [--merge]
+    [--drift-policy=<policy>]
+    [--init-rules=<rules-path>]
+    [--concurrency=<c>]
+    [--schema=<schema-path>]
+    [--state=<state-path>]
+    [--full-resync]
+    [--snapshot]
+    [--keys-dir=<keys-path>]
+    [--mask-key-file=<key-path>]
+    [--collection-template=<template>]
+    [--numeric-as-float]
+    [--special-values=<mode>]
+    [--stream]
+    [--slot=<slot-name>]
+    [--poll-interval=<interval>]
+    --write-key=<segment-write-key>
+    --hostname=<hostname>
+    --port=<port>
+    --username=<username>
+    --password=<password>
+    --database=<database>
+    [-- <extra-driver-options>...]
+  dbsource -h | --help
+  dbsource --version
+
+Options:
+    "github.com/segmentio/source-db-lib/internal/domain"
+  -h --help                   Show this screen
+  --version                   Show version
+  --write-key=<key>           Segment source write key
+  --concurrency=<c>           Number of concurrent table scans [default: 1]
+  --merge                     Merge the tables and columns of the database into the existing schema, with --init or before scanning
+  --drift-policy=<policy>     How --merge handles schema changes: add, ignore or fail [default: add]
+  --init-rules=<rules-path>   The path to a json file selecting the schemas, tables and columns added by --init and --merge
+  --hostname=<hostname>       Database instance hostname
+  --port=<port>               Database instance port number
+  --username=<username>       Database instance username
+  --password=<password>       Database instance password
+  --database=<database>       Database instance name
+  --schema=<schema-path>	  The path to the schema json file [default: schema.json]
+  --state=<state-path>	  The path to the state json file [default: state.json]
+  --full-resync               Ignore the saved state and scan every table from the beginning
+  --snapshot                  Scan every table from a single consistent database snapshot
+  --keys-dir=<keys-path>      The directory keeping the keys used to detect deletes [default: keys]
+  --mask-key-file=<key-path>  The file holding the key of hmac column masks, defaults to the MASK_KEY variable
+  --collection-template=<template>  Name of the collections of tables, {schema} and {table} are replaced [default: {schema}_{table}]
+  --numeric-as-float          Send arbitrary precision numbers as floats instead of decimal strings
+  --special-values=<mode>     Send NaN, Infinity and infinite timestamps as null or string [default: null]
+  --stream                    Stream changes from a logical replication slot instead of scanning tables
+  --slot=<slot-name>          Name of the replication slot used by --stream [default: segment_source]
+  --poll-interval=<interval>  How long --stream waits when there are no new changes [default: 10s]
+
+`
+
+func Run(d driver.Driver) {
+	app := &driver.Base{Driver: d}
+
+	m, err := docopt.Parse(usage, nil, true, Version, false)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+
+	app.CollectionTemplate = m["--collection-template"].(string)
+
+	config := &domain.Config{
+		Init:         m["--init"].(bool),
+		Hostname:     m["--hostname"].(string),
+		Port:         m["--port"].(string),
+		Username:     m["--username"].(string),
+		Password:     m["--password"].(string),
+		Database:     m["--database"].(string),
+		ExtraOptions: m["<extra-driver-options>"].([]string),
+
+		Snapshot:        m["--snapshot"].(bool),
+		NumericAsFloat:  m["--numeric-as-float"].(bool),
+		SpecialValues:   m["--special-values"].(string),
+		ReplicationSlot: m["--slot"].(string),
+	}
+
+	if m["--debug"].(bool) {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+
+	concurrency, err := strconv.Atoi(m["--concurrency"].(string))
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+
+	// Validate the configuration
+	if _, err := govalidator.ValidateStruct(config); err != nil {
+		logrus.Error(err)
+		return
+	}
+
+	// the exported snapshot would be held open, and keep its rows from being vacuumed, for as long as the stream runs
+	if config.Snapshot && m["--stream"].(bool) {
+		logrus.Error("--snapshot can't be used with --stream")
+		return
+	}
+
+	// Open the schema
+	schemaFile, err := os.OpenFile(m["--schema"].(string), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		logrus.Error(err)
+		return
+	}
+	defer schemaFile.Close()
+
+	if err := app.Driver.Init(config); err != nil {
+		logrus.Error(err)
+		return
+	}
+
+	// Initialize the source
+	if config.Init {
+		rulesPath, _ := m["--init-rules"].(string)
+		description, err := describe(app.Driver, rulesPath)
+		if err != nil {
+			logrus.Error(err)
+			return
+		}
+
+		existing, err := domain.NewDescriptionFromReader(schemaFile)
+		if err != nil && err != io.EOF {
+			// a schema that can't be read can only be replaced, unless it has to be merged
+			if m["--merge"].(bool) {
+				logrus.WithError(err).Error("Can't merge into the existing schema")
+				return
+			}
+			logrus.WithError(err).Warn("Replacing the existing schema, it can't be read")
+			existing = nil
+		}
+
+		if existing != nil && m["--merge"].(bool) {
+			if description, _, err = mergeDescription(existing, description, m["--drift-policy"].(string)); err != nil {
+				logrus.Error(err)
+				return
+			}
+		} else if existing != nil {
+			// query tables can't be discovered, keep the ones of the existing schema, along with the collections set
+			// to resolve collisions
+			for table := range existing.Iter() {
+				if table.Kind != domain.KindQuery {
+					if described, ok := description.Table(table.SchemaName, table.TableName); ok {
+						described.Collection = table.Collection
+					}
+					continue
+				}
+				description.AddTable(table)
+			}
+		}
+
+		for table := range description.Iter() {
+			if table.Kind != domain.KindQuery {
+				continue
+			}
+			if err := app.ValidateQuery(table); err != nil {
+				logrus.Error(err)
+				return
+			}
+		}
+
+		// tables sent to the collection of another one are saved disabled, so the schema can be fixed by setting
+		// their collections instead of being written from scratch
+		collisionsErr := app.ValidateCollections(description, true)
+		var disabled []string
+		if collisionsErr != nil {
+			disabled = app.DisableCollisions(description, true)
+		}
+
+		if err := saveDescription(schemaFile, description); err != nil {
+			logrus.Error(err)
+			return
+		}
+
+		if collisionsErr != nil {
+			logrus.WithField("disabled", strings.Join(disabled, ", ")).Error(collisionsErr)
+		}
+		return
+	}
+
+	description, err := domain.NewDescriptionFromReader(schemaFile)
+	if err == io.EOF {
+		logrus.Error("Empty schema, did you run `--init`?")
+		return
+	} else if err != nil {
+		logrus.Error(err)
+		return
+	}
+
+	if m["--merge"].(bool) {
+		// the rules the schema was initialized with keep what they left out from coming back as drift
+		rulesPath, _ := m["--init-rules"].(string)
+		live, err := describe(app.Driver, rulesPath)
+		if err != nil {
+			logrus.Error(err)
+			return
+		}
+		policy := m["--drift-policy"].(string)
+		merged, drift, err := mergeDescription(description, live, policy)
+		if err != nil {
+			logrus.Error(err)
+			return
+		}
+		description = merged
+		if policy == domain.DriftAdd && !drift.Empty() {
+			if err := saveDescription(schemaFile, description); err != nil {
+				logrus.Error(err)
+				return
+			}
+		}
+	}
+
+	if err := app.ValidateCollections(description, false); err != nil {
+		logrus.Error(err)
+		return
+	}
+
+	maskKeyPath, _ := m["--mask-key-file"].(string)
+	if app.MaskKey, err = readMaskKey(maskKeyPath); err != nil {
+		logrus.Error(err)
+		return
+	}
+
+	if m["--stream"].(bool) {
+		// streamed changes can't be skipped table by table, so every mask and ID column has to be valid, and filters
+		// can't be applied to them
+		for table := range description.Iter() {
+			if table.Disabled {
+				continue
+			}
+			if table.Filter != "" {
+				logrus.Errorf("%s.%s: filters are not applied to streamed changes, remove the filter or disable the table",
+					table.SchemaName, table.TableName)
+				return
+			}
+			if err := app.ValidateMasks(table); err != nil {
+				logrus.Error(err)
+				return
+			}
+			if err := app.ValidateIDColumns(table); err != nil {
+				logrus.Error(err)
+				return
+			}
+
+			// deletes of tables whose changes don't carry their ID columns would be sent as tombstones without an
+			// ID, rows identified by their location are never matched with their previous version anyway
+			if table.Identity == domain.IdentityRowLocation {
+				continue
+			}
+			streamed, err := app.StreamsIDColumns(table)
+			if err != nil {
+				logrus.Error(err)
+				return
+			}
+			if !streamed {
+				logrus.Errorf("%s.%s: the replica identity doesn't hold the id columns %v, set it to FULL",
+					table.SchemaName, table.TableName, table.IDColumns)
+				return
+			}
+		}
+
+		pollInterval, err := time.ParseDuration(m["--poll-interval"].(string))
+		if err != nil {
+			logrus.Error(err)
+			return
+		}
+		if err := stream(app, description, m["--write-key"].(string), pollInterval); err != nil {
+			logrus.Error(err)
+		}
+		return
+	}
+
+	statePath := m["--state"].(string)
+	state := domain.NewState()
+	if !m["--full-resync"].(bool) {
+		if state, err = readState(statePath); err != nil {
+			logrus.Error(err)
+			return
+		}
+	}
+
+	app.KeysDir = m["--keys-dir"].(string)
+	app.Checkpoint = func(t *domain.Table) error {
+		state.Update(t)
+		return writeState(statePath, state)
+	}
+
+	segmentClient := newPublisher(m["--write-key"].(string))
+	app.Flush = segmentClient.Flush
+
+	var finishedMu sync.Mutex
+	finished := []*domain.Table{}
+
+	sem := make(semaphore.Semaphore, concurrency)
+
+	for table := range description.Iter() {
+		if table.Disabled {
+			continue
+		}
+		if err := table.Validate(); err != nil {
+			logrus.Error(err)
+			continue
+		}
+		if table.Kind == domain.KindQuery {
+			if err := app.ValidateQuery(table); err != nil {
+				logrus.Error(err)
+				continue
+			}
+		}
+		if err := app.ValidateFilter(table); err != nil {
+			logrus.Error(err)
+			continue
+		}
+		if err := app.ValidateIDColumns(table); err != nil {
+			logrus.Error(err)
+			continue
+		}
+		if err := app.ValidateMasks(table); err != nil {
+			logrus.Error(err)
+			continue
+		}
+		state.Restore(table)
+		sem.Acquire()
+		go func(table *domain.Table) {
+			defer sem.Release()
+			logrus.WithFields(logrus.Fields{"table": table.TableName, "schema": table.SchemaName}).Info("Scan started")
+			if err := app.ScanTable(table, segmentClient.Publish); err != nil {
+				logrus.Error(err)
+			} else {
+				finishedMu.Lock()
+				finished = append(finished, table)
+				finishedMu.Unlock()
+			}
+			logrus.WithFields(logrus.Fields{"table": table.TableName, "schema": table.SchemaName}).Info("Scan finished")
+		}(table)
+	}
+
+	sem.Wait()
+
+	// Only record finished scans once every object has been delivered, so the next run never skips unsent rows
+	if err := segmentClient.Close(); err != nil {
+		logrus.WithError(err).Error("Finished scans are not recorded, they will resume from their last checkpoint")
+		finished = nil
+	}
+	for _, table := range finished {
+		state.Update(table)
+		if err := app.CommitKeys(table); err != nil {
+			logrus.Error(err)
+		}
+	}
+	if err := writeState(statePath, state); err != nil {
+		logrus.Error(err)
+	}
+
+	// Log status
+	for table := range description.Iter() {
+		if table.Disabled {
+			continue
+		}
+		logrus.WithFields(logrus.Fields{"schema": table.SchemaName, "table": table.TableName, "count": table.State.ScannedRows, "substituted": table.State.SubstitutedValues}).Info("Sync Finished")
+	}
+}
+
+// mergeDescription merges the live description into the saved one according to the drift policy, logging the
+// differences found.
+func mergeDescription(saved, live *domain.Description, policy string) (*domain.Description, *domain.Drift, error) {
+	merged, drift, err := domain.Merge(saved, live, policy)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, t := range drift.AddedTables {
+		logrus.WithFields(logrus.Fields{"table": t, "policy": policy}).Warn("Table added")
+	}
+	for _, t := range drift.RemovedTables {
+		logrus.WithFields(logrus.Fields{"table": t, "policy": policy}).Warn("Table removed")
+	}
+	for t, columns := range drift.AddedColumns {
+		logrus.WithFields(logrus.Fields{"table": t, "columns": columns, "policy": policy}).Warn("Columns added")
+	}
+	for t, columns := range drift.RemovedColumns {
+		logrus.WithFields(logrus.Fields{"table": t, "columns": columns, "policy": policy}).Warn("Columns removed")
+	}
+	for t, identity := range drift.ChangedIdentities {
+		logrus.WithFields(logrus.Fields{"table": t, "identity": identity, "policy": policy}).Warn("Identity changed")
+	}
+
+	return merged, drift, nil
+}
+
+// saveDescription replaces the content of the schema file with the description.
+func saveDescription(schemaFile *os.File, description *domain.Description) error {
+	if _, err := schemaFile.Seek(0, os.SEEK_SET); err != nil {
+		return err
+	}
+	if err := schemaFile.Truncate(0); err != nil {
+		return err
+	}
+	if err := description.Save(schemaFile); err != nil {
+		return err
+	}
+	if err := schemaFile.Sync(); err != nil {
+		return err
+	}
+
+	logrus.Infof("Saved to `%s`", schemaFile.Name())
+	return nil
+}
+
+// describe describes the database, keeping the schemas, tables and columns selected by the rules at rulesPath if it
+// is set.
+func describe(d driver.Driver, rulesPath string) (*domain.Description, error) {
+	description, err := d.Describe()
+	if err != nil {
+		return nil, err
+	}
+	if rulesPath == "" {
+		return description, nil
+	}
+
+	rules, err := readRules(rulesPath)
+	if err != nil {
+		return nil, err
+	}
+	return description.Select(rules), nil
+}
+
+// readRules loads the rules selecting what --init adds to the schema.
+func readRules(path string) (*domain.Rules, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return domain.NewRulesFromReader(f)
+}
+
+// readMaskKey reads the key of hmac column masks from the file at path, or from the MASK_KEY environment variable if
+// no path is given. Trailing newlines are not part of the key.
+func readMaskKey(path string) ([]byte, error) {
+	if path == "" {
+		return []byte(os.Getenv("MASK_KEY")), nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(b, "\r\n"), nil
+}
+
+// stateMu serializes writes of the state file so an older state can never replace a newer one.
+var stateMu sync.Mutex
+
+// readState loads the state saved by a previous run, or returns an empty state if there is none.
+func readState(path string) (*domain.State, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return domain.NewState(), nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	state, err := domain.NewStateFromReader(f)
+	if err == io.EOF {
+		return domain.NewState(), nil
+	}
+	return state, err
+}
+
+// writeState saves the state to a temporary file and renames it over path, so an interrupted write never leaves
+// a truncated state file behind.
+func writeState(path string, state *domain.State) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+
+	if err := state.Save(f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,49 @@
+package sqlsource
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/segment-sources/source-postgres/sqlsource/domain"
+	"github.com/segment-sources/source-postgres/sqlsource/driver"
+)
+
+// streamBatchSize is the number of changes read from the driver before they are flushed and acknowledged.
+const streamBatchSize = 10000
+
+// stream continuously publishes the changes reported by the driver. A batch is only acknowledged once every one of
+// its objects was delivered to the Objects API. If any of them was not, streaming stops without acknowledging it, so
+// the batch is read again when the source is restarted and some changes may be sent twice.
+func stream(app *driver.Base, description *domain.Description, writeKey string, pollInterval time.Duration) error {
+	sd, ok := app.Driver.(driver.StreamDriver)
+	if !ok {
+		return fmt.Errorf("driver does not support streaming")
+	}
+
+	for {
+		changes, position, err := sd.Changes(description, streamBatchSize)
+		if err != nil {
+			return err
+		}
+
+		if len(changes) > 0 {
+			segmentClient := newPublisher(writeKey)
+			app.PublishChanges(description, changes, segmentClient.Publish)
+			if err := segmentClient.Close(); err != nil {
+				return fmt.Errorf("changes up to %s were not acknowledged: %v", position, err)
+			}
+		}
+
+		if position != "" {
+			if err := sd.Ack(description, position); err != nil {
+				return err
+			}
+			logrus.WithFields(logrus.Fields{"position": position, "count": len(changes)}).Info("Changes published")
+		}
+
+		if len(changes) < streamBatchSize {
+			time.Sleep(pollInterval)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package postgres
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/segment-sources/source-postgres/sqlsource/domain"
+	"github.com/segment-sources/source-postgres/sqlsource/driver"
+)
+
+// outputPlugin is the logical decoding plugin used for streaming, it has to be installed on the server.
+const outputPlugin = "wal2json"
+
+// slotOptions are the wal2json options passed when reading the slot, the list of tables is bound as the third
+// query argument.
+const slotOptions = `'format-version', '2', 'include-types', 'false', 'add-tables', $3`
+
+type walChange struct {
+	Action   string      `json:"action"`
+	Schema   string      `json:"schema"`
+	Table    string      `json:"table"`
+	Columns  []walColumn `json:"columns"`
+	Identity []walColumn `json:"identity"`
+}
+
+type walColumn struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+}
+
+// decodeWalChange decodes a wal2json message. Changes of partitions are reported under the name of their
+// partitioned table, found in parents by the qualified name of the partition.
+func decodeWalChange(data string, parents map[string]*domain.Table) (*walChange, error) {
+	dec := json.NewDecoder(strings.NewReader(data))
+	dec.UseNumber()
+	c := &walChange{}
+	if err := dec.Decode(c); err != nil {
+		return nil, err
+	}
+
+	if parent, ok := parents[c.Schema+"."+c.Table]; ok {
+		c.Schema, c.Table = parent.SchemaName, parent.TableName
+	}
+	return c, nil
+}
+
+// change returns the row change of an insert, update or delete, and nil for other messages.
+func (c *walChange) change() *driver.Change {
+	switch c.Action {
+	case "I":
+		return &driver.Change{Schema: c.Schema, Table: c.Table, Row: c.row(c.Columns)}
+	case "U":
+		change := &driver.Change{Schema: c.Schema, Table: c.Table, Row: c.row(c.Columns)}
+		if len(c.Identity) > 0 {
+			change.OldKey = c.row(c.Identity)
+		}
+		return change
+	case "D":
+		return &driver.Change{Schema: c.Schema, Table: c.Table, Row: c.row(c.Identity), Deleted: true}
+	}
+	return nil
+}
+
+func (c *walChange) row(columns []walColumn) map[string]interface{} {
+	row := make(map[string]interface{}, len(columns))
+	for _, column := range columns {
+		row[column.Name] = column.Value
+	}
+	return row
+}
+
+// Changes peeks at the pending changes of the replication slot, creating it if it does not exist yet. Changes
+// are only consumed from the slot by Ack, so they will be read again if the process stops before acknowledging.
+func (p *Postgres) Changes(d *domain.Description, limit int) ([]*driver.Change, string, error) {
+	if err := p.ensureSlot(); err != nil {
+		return nil, "", err
+	}
+
+	query := fmt.Sprintf(`SELECT lsn::text, data FROM pg_logical_slot_peek_changes($1, NULL, $2, %s)`,
+		slotOptions)
+
+	tables, parents, err := p.streamTables(d)
+	if err != nil {
+		return nil, "", err
+	}
+
+	rows, err := p.Connection.Queryx(query, p.slot, limit, tables)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	changes := []*driver.Change{}
+	position := ""
+	for rows.Next() {
+		var lsn, data string
+		if err := rows.Scan(&lsn, &data); err != nil {
+			return nil, "", err
+		}
+
+		c, err := decodeWalChange(data, parents)
+		if err != nil {
+			return nil, "", err
+		}
+
+		switch c.Action {
+		case "C":
+			// only positions at transaction boundaries are safe to acknowledge
+			position = lsn
+		case "T":
+			logrus.WithFields(logrus.Fields{"schema": c.Schema, "table": c.Table}).Warn("Table truncated, rows are not removed from Segment")
+		default:
+			if change := c.change(); change != nil {
+				changes = append(changes, change)
+			}
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	return changes, position, nil
+}
+
+// Ack consumes the changes of the replication slot up to and including the transaction committed at position. The
+// slot is advanced without decoding the changes into messages again.
+func (p *Postgres) Ack(d *domain.Description, position string) error {
+	_, err := p.Connection.Exec(`SELECT pg_replication_slot_advance($1, $2::pg_lsn)`, p.slot, position)
+	return err
+}
+
+func (p *Postgres) ensureSlot() error {
+	if p.slotReady {
+		return nil
+	}
+
+	var exists bool
+	err := p.Connection.QueryRowx(`SELECT EXISTS (SELECT 1 FROM pg_replication_slots WHERE slot_name = $1)`,
+		p.slot).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		logrus.WithFields(logrus.Fields{"slot": p.slot, "plugin": outputPlugin}).Info("Creating replication slot")
+		if _, err := p.Connection.Exec(`SELECT pg_create_logical_replication_slot($1, $2)`, p.slot, outputPlugin); err != nil {
+			return err
+		}
+	}
+
+	p.slotReady = true
+	return nil
+}
+
+// streamTables returns the value of wal2json's add-tables option listing every table of the description, along
+// with the partitioned tables the listed partitions belong to.
+func (p *Postgres) streamTables(d *domain.Description) (string, map[string]*domain.Table, error) {
+	enabled := []*domain.Table{}
+	for t := range d.Iter() {
+		if !t.Disabled && t.Kind != domain.KindQuery {
+			enabled = append(enabled, t)
+		}
+	}
+
+	tables := []string{}
+	parents := map[string]*domain.Table{}
+	for _, t := range enabled {
+		if err := p.columnTypes(t); err != nil {
+			return "", nil, err
+		}
+		tables = append(tables, escapeTableName(t.SchemaName)+"."+escapeTableName(t.TableName))
+
+		if t.Kind != domain.KindPartitioned {
+			continue
+		}
+		leaves, err := p.leafPartitions(t)
+		if err != nil {
+			return "", nil, err
+		}
+		for _, leaf := range leaves {
+			tables = append(tables, escapeTableName(leaf.SchemaName)+"."+escapeTableName(leaf.TableName))
+			parents[leaf.SchemaName+"."+leaf.TableName] = t
+		}
+	}
+	return strings.Join(tables, ","), parents, nil
+}
+
+// escapeTableName escapes the characters that have a special meaning in wal2json table lists.
+func escapeTableName(name string) string {
+	var b bytes.Buffer
+	for _, r := range name {
+		switch r {
+		case ',', '.', '*', ' ', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
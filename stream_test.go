@@ -0,0 +1,107 @@
+package postgres
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/segment-sources/source-postgres/sqlsource/domain"
+	"github.com/segment-sources/source-postgres/sqlsource/driver"
+)
+
+func TestDecodeWalChange(t *testing.T) {
+	parents := map[string]*domain.Table{
+		"public.events_1": {SchemaName: "public", TableName: "events"},
+	}
+
+	tests := []struct {
+		name     string
+		data     string
+		action   string
+		expected *driver.Change
+	}{
+		{
+			name:   "insert",
+			data:   `{"action":"I","schema":"public","table":"films","columns":[{"name":"id","value":12345678901234567890},{"name":"title","value":"Vertigo"}]}`,
+			action: "I",
+			expected: &driver.Change{Schema: "public", Table: "films",
+				Row: map[string]interface{}{"id": json.Number("12345678901234567890"), "title": "Vertigo"}},
+		},
+		{
+			name:   "update of the key",
+			data:   `{"action":"U","schema":"public","table":"films","columns":[{"name":"id","value":2},{"name":"title","value":null}],"identity":[{"name":"id","value":1}]}`,
+			action: "U",
+			expected: &driver.Change{Schema: "public", Table: "films",
+				Row:    map[string]interface{}{"id": json.Number("2"), "title": nil},
+				OldKey: map[string]interface{}{"id": json.Number("1")}},
+		},
+		{
+			name:   "update",
+			data:   `{"action":"U","schema":"public","table":"films","columns":[{"name":"id","value":2}]}`,
+			action: "U",
+			expected: &driver.Change{Schema: "public", Table: "films",
+				Row: map[string]interface{}{"id": json.Number("2")}},
+		},
+		{
+			name:   "delete",
+			data:   `{"action":"D","schema":"public","table":"films","identity":[{"name":"id","value":2}]}`,
+			action: "D",
+			expected: &driver.Change{Schema: "public", Table: "films",
+				Row: map[string]interface{}{"id": json.Number("2")}, Deleted: true},
+		},
+		{
+			name:   "insert into a partition",
+			data:   `{"action":"I","schema":"public","table":"events_1","columns":[{"name":"id","value":1}]}`,
+			action: "I",
+			expected: &driver.Change{Schema: "public", Table: "events",
+				Row: map[string]interface{}{"id": json.Number("1")}},
+		},
+		{
+			name:   "commit",
+			data:   `{"action":"C"}`,
+			action: "C",
+		},
+		{
+			name:   "truncate",
+			data:   `{"action":"T","schema":"public","table":"films"}`,
+			action: "T",
+		},
+	}
+
+	for _, test := range tests {
+		c, err := decodeWalChange(test.data, parents)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		if c.Action != test.action {
+			t.Errorf("%s: expected the action %s, got %s", test.name, test.action, c.Action)
+		}
+		if change := c.change(); !reflect.DeepEqual(change, test.expected) {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.expected, change)
+		}
+	}
+
+	if _, err := decodeWalChange(`{"action":`, parents); err == nil {
+		t.Error("expected a truncated message to fail")
+	}
+}
+
+func TestEscapeTableName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"films", "films"},
+		{"my table", `my\ table`},
+		{"a.b,c*d", `a\.b\,c\*d`},
+		{`back\slash`, `back\\slash`},
+		{"Émile", "Émile"},
+	}
+
+	for _, test := range tests {
+		if actual := escapeTableName(test.name); actual != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,438 @@
+package postgres
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segment-sources/source-postgres/sqlsource/domain"
+)
+
+// textTimestampLayouts are the layouts timestamps are printed with by Postgres, as found in logical replication
+// messages.
+var textTimestampLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07:00:00",
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999-07",
+	"2006-01-02 15:04:05.999999999",
+}
+
+// specialValue is returned by converters for values that can't be sent as is, such as NaN floats, Transform
+// replaces them according to the configured representation.
+type specialValue string
+
+const (
+	specialNaN              specialValue = "NaN"
+	specialInfinity         specialValue = "Infinity"
+	specialNegativeInfinity specialValue = "-Infinity"
+	specialInfiniteTime     specialValue = "infinity"
+	specialNegativeTime     specialValue = "-infinity"
+)
+
+// Infinite dates and timestamps are decoded by pgx into these times.
+var (
+	infinityTimestamp         = pgxTimestamp(math.MaxInt64)
+	negativeInfinityTimestamp = pgxTimestamp(math.MinInt64)
+	infinityDate              = pgxDate(math.MaxInt32)
+	negativeInfinityDate      = pgxDate(math.MinInt32)
+)
+
+// pgxTimestamp returns the time pgx decodes a timestamp of microseconds since 2000-01-01 into, overflow included.
+func pgxTimestamp(microsecSinceY2K int64) time.Time {
+	microsecSinceUnixEpoch := 946684800000000 + microsecSinceY2K
+	return time.Unix(microsecSinceUnixEpoch/1000000, (microsecSinceUnixEpoch%1000000)*1000)
+}
+
+// pgxDate returns the time pgx decodes a date of days since 2000-01-01 into, overflow included.
+func pgxDate(dayOffset int32) time.Time {
+	return time.Date(2000, 1, int(1+dayOffset), 0, 0, 0, 0, time.Local)
+}
+
+// converter converts a value read from Postgres into the value sent to Segment.
+type converter func(p *Postgres, v interface{}) interface{}
+
+// converters are keyed on the name of the Postgres type of the column.
+var converters = map[string]converter{
+	"numeric":     convertNumeric,
+	"bytea":       convertBytea,
+	"timestamp":   convertTimestamp,
+	"timestamptz": convertTimestamp,
+	"date":        convertDate,
+	"uuid":        convertText,
+	"inet":        convertText,
+	"cidr":        convertText,
+	"macaddr":     convertText,
+	"interval":    convertText,
+	"json":        convertJSON,
+	"jsonb":       convertJSON,
+	"int2":        convertInteger,
+	"int4":        convertInteger,
+	"int8":        convertInteger,
+	"oid":         convertInteger,
+	"float4":      convertFloat,
+	"float8":      convertFloat,
+	"bool":        convertBool,
+	"geometry":    convertGeometry,
+	"geography":   convertGeometry,
+}
+
+// Transform converts the values of the row based on the Postgres type of their column. Values of columns whose type
+// is unknown are converted based on their Go type.
+func (p *Postgres) Transform(t *domain.Table, row map[string]interface{}) map[string]interface{} {
+	types := p.cachedColumnTypes(t)
+
+	jsonAsString := map[string]bool{}
+	for _, column := range t.JSONAsString {
+		jsonAsString[column] = true
+	}
+	latLon := map[string]bool{}
+	for _, column := range t.LatLon {
+		latLon[column] = true
+	}
+
+	for column, v := range row {
+		if v == nil {
+			continue
+		}
+		if jsonAsString[column] && (types[column] == "json" || types[column] == "jsonb") {
+			row[column] = convertText(p, v)
+			continue
+		}
+		converted := p.convert(types[column], v)
+		if latLon[column] {
+			converted = pointLatLon(converted)
+		}
+		row[column] = p.substitute(t, converted)
+	}
+
+	return row
+}
+
+func (p *Postgres) convert(typeName string, v interface{}) interface{} {
+	if strings.HasPrefix(typeName, "_") {
+		return convertArray(p, typeName[1:], v)
+	}
+	if convert, ok := converters[typeName]; ok {
+		return convert(p, v)
+	}
+
+	switch v := v.(type) {
+	case time.Time:
+		return convertTimestamp(p, v)
+	case []byte:
+		return convertBytea(p, v)
+	case float64, float32:
+		return convertFloat(p, v)
+	}
+	return v
+}
+
+// substitute replaces the special values found in v, including in arrays and in objects such as GeoJSON
+// geometries, and counts them.
+func (p *Postgres) substitute(t *domain.Table, v interface{}) interface{} {
+	switch v := v.(type) {
+	case specialValue:
+		t.IncrSubstituted()
+		if p.specialValues == domain.SpecialValuesString {
+			return string(v)
+		}
+		return nil
+	case []interface{}:
+		for i, element := range v {
+			v[i] = p.substitute(t, element)
+		}
+	case map[string]interface{}:
+		for key, element := range v {
+			v[key] = p.substitute(t, element)
+		}
+	}
+	return v
+}
+
+// columnTypes loads the names of the types of the table's columns, resolving domains to their base type, and
+// caches them for Transform. Query tables are not in the catalog and rely on their column metadata instead.
+func (p *Postgres) columnTypes(t *domain.Table) error {
+	key := fmt.Sprintf("%s.%s", t.SchemaName, t.TableName)
+
+	p.typesMu.Lock()
+	_, ok := p.types[key]
+	p.typesMu.Unlock()
+	if ok {
+		return nil
+	}
+
+	types := map[string]string{}
+	for column, m := range t.ColumnMetadata {
+		types[column] = m.UDTName
+	}
+
+	if t.Kind != domain.KindQuery {
+		rows, err := p.query(`
+			SELECT a.attname, CASE WHEN _ty.typtype = 'd' THEN _bt.typname ELSE _ty.typname END
+			FROM pg_catalog.pg_attribute a
+			  INNER JOIN pg_catalog.pg_type _ty ON a.atttypid = _ty.oid
+			  LEFT JOIN pg_catalog.pg_type _bt ON _ty.typbasetype = _bt.oid
+			WHERE a.attrelid = ($1::text)::regclass AND a.attnum > 0 AND NOT a.attisdropped`,
+			fmt.Sprintf("%q.%q", t.SchemaName, t.TableName))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var column, typeName string
+			if err := rows.Scan(&column, &typeName); err != nil {
+				return err
+			}
+			types[column] = typeName
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	p.typesMu.Lock()
+	p.types[key] = types
+	p.typesMu.Unlock()
+
+	return nil
+}
+
+func (p *Postgres) cachedColumnTypes(t *domain.Table) map[string]string {
+	p.typesMu.Lock()
+	defer p.typesMu.Unlock()
+
+	return p.types[fmt.Sprintf("%s.%s", t.SchemaName, t.TableName)]
+}
+
+// convertNumeric returns numerics as decimal strings, so no precision is lost, or as floats if configured to.
+func convertNumeric(p *Postgres, v interface{}) interface{} {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case json.Number:
+		s = v.String()
+	default:
+		return v
+	}
+
+	if special, ok := specialFloat(s); ok {
+		return special
+	}
+	if !p.numericAsFloat {
+		return s
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	return s
+}
+
+// convertBytea returns binary values as base64. Values from logical replication messages are in Postgres' hex
+// format.
+func convertBytea(p *Postgres, v interface{}) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	case string:
+		if strings.HasPrefix(v, `\x`) {
+			if b, err := hex.DecodeString(v[2:]); err == nil {
+				return base64.StdEncoding.EncodeToString(b)
+			}
+		}
+	}
+	return v
+}
+
+// convertTimestamp returns timestamps as RFC 3339 strings in UTC.
+func convertTimestamp(p *Postgres, v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		if v.Equal(infinityTimestamp) {
+			return specialInfiniteTime
+		} else if v.Equal(negativeInfinityTimestamp) {
+			return specialNegativeTime
+		}
+		return v.UTC().Format(time.RFC3339Nano)
+	case string:
+		if special, ok := specialTime(v); ok {
+			return special
+		}
+		for _, layout := range textTimestampLayouts {
+			if ts, err := time.Parse(layout, v); err == nil {
+				return ts.UTC().Format(time.RFC3339Nano)
+			}
+		}
+	}
+	return v
+}
+
+// convertJSON decodes json values into nested objects and arrays. Values that can't be decoded are sent as is.
+func convertJSON(p *Postgres, v interface{}) interface{} {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return v
+	}
+
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var decoded interface{}
+	if err := dec.Decode(&decoded); err != nil {
+		return s
+	}
+	return decoded
+}
+
+// convertArray converts arrays into JSON arrays, converting their elements based on the element type. Arrays are
+// read in their text representation, so elements of intrinsic types are parsed too.
+func convertArray(p *Postgres, elementType string, v interface{}) interface{} {
+	s, ok := v.(string)
+	if !ok {
+		return v
+	}
+
+	parsed, err := parseArray(s)
+	if err != nil {
+		return s
+	}
+
+	convert, ok := converters[elementType]
+	if !ok {
+		return parsed
+	}
+	return convertElements(p, convert, parsed)
+}
+
+func convertElements(p *Postgres, convert converter, v interface{}) interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		for i, element := range v {
+			v[i] = convertElements(p, convert, element)
+		}
+		return v
+	case nil:
+		return nil
+	default:
+		return convert(p, v)
+	}
+}
+
+// convertInteger parses the text representation of integers, as found in arrays.
+func convertInteger(p *Postgres, v interface{}) interface{} {
+	if s, ok := v.(string); ok {
+		if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return json.Number(s)
+		}
+	}
+	return v
+}
+
+// convertFloat replaces NaN and infinite floats, and parses the text representation of floats, as found in arrays.
+func convertFloat(p *Postgres, v interface{}) interface{} {
+	var f float64
+	switch v := v.(type) {
+	case float64:
+		f = v
+	case float32:
+		f = float64(v)
+	case string:
+		if special, ok := specialFloat(v); ok {
+			return special
+		}
+		parsed, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return v
+		}
+		f = parsed
+	default:
+		return v
+	}
+
+	switch {
+	case math.IsNaN(f):
+		return specialNaN
+	case math.IsInf(f, 1):
+		return specialInfinity
+	case math.IsInf(f, -1):
+		return specialNegativeInfinity
+	}
+	return f
+}
+
+// specialFloat recognizes the text representation of NaN and infinite floats and numerics.
+func specialFloat(s string) (specialValue, bool) {
+	switch s {
+	case "NaN":
+		return specialNaN, true
+	case "Infinity":
+		return specialInfinity, true
+	case "-Infinity":
+		return specialNegativeInfinity, true
+	}
+	return "", false
+}
+
+// specialTime recognizes the text representation of infinite dates and timestamps.
+func specialTime(s string) (specialValue, bool) {
+	switch s {
+	case "infinity":
+		return specialInfiniteTime, true
+	case "-infinity":
+		return specialNegativeTime, true
+	}
+	return "", false
+}
+
+// convertBool parses the text representation of booleans, as found in arrays.
+func convertBool(p *Postgres, v interface{}) interface{} {
+	switch v {
+	case "t":
+		return true
+	case "f":
+		return false
+	}
+	return v
+}
+
+// convertDate returns dates without a time.
+func convertDate(p *Postgres, v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		if v.Equal(infinityDate) {
+			return specialInfiniteTime
+		} else if v.Equal(negativeInfinityDate) {
+			return specialNegativeTime
+		}
+		return v.Format("2006-01-02")
+	case string:
+		if special, ok := specialTime(v); ok {
+			return special
+		}
+	}
+	return v
+}
+
+// convertText returns the text representation of values, which is what Postgres sends for types such as uuid and
+// inet. Intervals are printed as ISO 8601 durations since the connection sets IntervalStyle.
+func convertText(p *Postgres, v interface{}) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		return string(v)
+	case fmt.Stringer:
+		return v.String()
+	}
+	return v
+}
@@ -0,0 +1,128 @@
+package postgres
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/segment-sources/source-postgres/sqlsource/domain"
+)
+
+func TestConverters(t *testing.T) {
+	p := &Postgres{}
+	asFloat := &Postgres{numericAsFloat: true}
+	pst := time.FixedZone("PST", -8*60*60)
+
+	tests := []struct {
+		name     string
+		p        *Postgres
+		convert  converter
+		value    interface{}
+		expected interface{}
+	}{
+		{"numeric string", p, convertNumeric, "12345678901234567890.123", "12345678901234567890.123"},
+		{"numeric bytes", p, convertNumeric, []byte("1.50"), "1.50"},
+		{"numeric from wal2json", p, convertNumeric, json.Number("1.50"), "1.50"},
+		{"numeric NaN", p, convertNumeric, "NaN", specialNaN},
+		{"numeric as float", asFloat, convertNumeric, []byte("1.50"), 1.5},
+		{"numeric as float NaN", asFloat, convertNumeric, json.Number("NaN"), specialNaN},
+		{"numeric of another type", p, convertNumeric, int64(1), int64(1)},
+
+		{"bytea", p, convertBytea, []byte{0xde, 0xad, 0xbe, 0xef}, "3q2+7w=="},
+		{"bytea from wal2json", p, convertBytea, `\xdeadbeef`, "3q2+7w=="},
+		{"empty bytea from wal2json", p, convertBytea, `\x`, ""},
+		{"bytea with invalid hex", p, convertBytea, `\xzz`, `\xzz`},
+		{"bytea escape format", p, convertBytea, `abc`, `abc`},
+
+		{"timestamp in UTC", p, convertTimestamp, time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC), "2017-01-02T03:04:05Z"},
+		{"timestamp in another zone", p, convertTimestamp, time.Date(2017, 1, 2, 3, 4, 5, 600000000, pst),
+			"2017-01-02T11:04:05.6Z"},
+		{"infinite timestamp", p, convertTimestamp, infinityTimestamp, specialInfiniteTime},
+		{"negative infinite timestamp", p, convertTimestamp, negativeInfinityTimestamp, specialNegativeTime},
+		{"timestamptz text with seconds in the offset", p, convertTimestamp, "1850-01-02 03:04:05-07:52:58",
+			"1850-01-02T10:57:03Z"},
+		{"timestamptz text with minutes in the offset", p, convertTimestamp, "2017-01-02 03:04:05.123456+05:30",
+			"2017-01-01T21:34:05.123456Z"},
+		{"timestamptz text", p, convertTimestamp, "2017-01-02 03:04:05-08", "2017-01-02T11:04:05Z"},
+		{"timestamp text", p, convertTimestamp, "2017-01-02 03:04:05.5", "2017-01-02T03:04:05.5Z"},
+		{"infinite timestamp text", p, convertTimestamp, "-infinity", specialNegativeTime},
+		{"unknown timestamp text", p, convertTimestamp, "2017-01-02 03:04:05 BC", "2017-01-02 03:04:05 BC"},
+
+		{"date", p, convertDate, time.Date(2017, 1, 2, 0, 0, 0, 0, time.Local), "2017-01-02"},
+		{"infinite date", p, convertDate, infinityDate, specialInfiniteTime},
+		{"negative infinite date", p, convertDate, negativeInfinityDate, specialNegativeTime},
+		{"date text", p, convertDate, "2017-01-02", "2017-01-02"},
+		{"infinite date text", p, convertDate, "infinity", specialInfiniteTime},
+
+		{"text bytes", p, convertText, []byte("192.168.0.1/32"), "192.168.0.1/32"},
+		{"text stringer", p, convertText, 90 * time.Second, "1m30s"},
+		{"text string", p, convertText, "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11", "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"},
+	}
+
+	for _, test := range tests {
+		if actual := test.convert(test.p, test.value); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: expected %#v, got %#v", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestConvertArray(t *testing.T) {
+	p := &Postgres{}
+
+	tests := []struct {
+		typeName string
+		value    interface{}
+		expected interface{}
+	}{
+		{"_int4", "{1,NULL,3}", []interface{}{json.Number("1"), nil, json.Number("3")}},
+		{"_float8", "{{1.5,NaN},{Infinity,-2}}",
+			[]interface{}{[]interface{}{1.5, specialNaN}, []interface{}{specialInfinity, -2.0}}},
+		{"_bool", "{t,f}", []interface{}{true, false}},
+		{"_timestamptz", `{"2017-01-02 03:04:05-08",infinity}`,
+			[]interface{}{"2017-01-02T11:04:05Z", specialInfiniteTime}},
+		{"_bytea", `{"\\xdeadbeef"}`, []interface{}{"3q2+7w=="}},
+		{"_text", `{a,"b c"}`, []interface{}{"a", "b c"}},
+		{"_int4", "{1,", "{1,"},
+		{"_int4", []byte("{1}"), []byte("{1}")},
+	}
+
+	for _, test := range tests {
+		if actual := p.convert(test.typeName, test.value); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s %v: expected %#v, got %#v", test.typeName, test.value, test.expected, actual)
+		}
+	}
+}
+
+func TestTransformSpecialValues(t *testing.T) {
+	table := &domain.Table{SchemaName: "public", TableName: "readings"}
+	row := func() map[string]interface{} {
+		return map[string]interface{}{
+			"value":  "NaN",
+			"values": "{1,Infinity}",
+			"at":     infinityTimestamp,
+			"note":   nil,
+		}
+	}
+
+	tests := []struct {
+		specialValues string
+		expected      map[string]interface{}
+	}{
+		{domain.SpecialValuesNull, map[string]interface{}{
+			"value": nil, "values": []interface{}{1.0, nil}, "at": nil, "note": nil,
+		}},
+		{domain.SpecialValuesString, map[string]interface{}{
+			"value": "NaN", "values": []interface{}{1.0, "Infinity"}, "at": "infinity", "note": nil,
+		}},
+	}
+
+	for _, test := range tests {
+		p := &Postgres{specialValues: test.specialValues, types: map[string]map[string]string{
+			"public.readings": {"value": "numeric", "values": "_float8", "at": "timestamptz", "note": "text"},
+		}}
+		if actual := p.Transform(table, row()); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.specialValues, test.expected, actual)
+		}
+	}
+}